@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/henrywallace/netwatch/util"
+	"github.com/henrywallace/netwatch/watch"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Work with netwatch config files",
+}
+
+var configCheckCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Check a config file for problems",
+	Args:  cobra.NoArgs,
+	RunE:  configCheck,
+	// Problems with the config are the output itself, so don't bury them
+	// under usage, or print them twice.
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
+
+var configSchemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Print a JSON Schema of config files, for editors to check them",
+	Args:  cobra.NoArgs,
+	RunE:  configSchema,
+}
+
+func init() {
+	configCheckCmd.Flags().StringP("config", "c", "config.toml", "toml, yaml or json file to check")
+	configCheckCmd.Flags().StringSlice("profile", nil, "config profiles to apply, in order")
+	configCmd.AddCommand(configCheckCmd)
+	configCmd.AddCommand(configSchemaCmd)
+	rootCmd.AddCommand(configCmd)
+}
+
+func configCheck(cmd *cobra.Command, args []string) error {
+	log := util.NewLogger()
+	path := mustString(log, cmd, "config")
+	profiles := mustStringSlice(log, cmd, "profile")
+	if _, err := watch.LoadConfig(path, profiles...); err != nil {
+		return err
+	}
+	fmt.Printf("%s: ok\n", path)
+	return nil
+}
+
+func configSchema(cmd *cobra.Command, args []string) error {
+	b, err := json.MarshalIndent(watch.ConfigSchema(), "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
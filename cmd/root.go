@@ -22,7 +22,8 @@ var rootCmd = &cobra.Command{
 }
 
 func init() {
-	rootCmd.Flags().StringP("config", "c", "config.toml", "toml file to trigger config")
+	rootCmd.Flags().StringP("config", "c", "config.toml", "toml, yaml or json file to trigger config")
+	rootCmd.Flags().StringSlice("profile", nil, "config profiles to apply, in order")
 	rootCmd.Flags().StringSliceP("only", "o", nil, "config trigger names to only run")
 	rootCmd.Flags().StringP("iface", "i", "", "which network interface to use, if not first active")
 	rootCmd.Flags().StringP("pcap", "p", "", "whether to read from pcap file instead of live interface")
@@ -33,10 +34,17 @@ func main(cmd *cobra.Command, args []string) error {
 	log := util.NewLogger()
 
 	var subs []watch.Subscriber
+	var conf *watch.Config
 	path := mustString(log, cmd, "config")
+	profiles := mustStringSlice(log, cmd, "profile")
 	only := mustStringSlice(log, cmd, "only")
 	if path != "" {
-		sub, err := watch.NewSubConfig(log, path, only)
+		var err error
+		conf, err = watch.LoadConfig(path, profiles...)
+		if err != nil {
+			return err
+		}
+		sub, err := watch.NewSubConfig(ctx, log, conf, only)
 		if err != nil {
 			return err
 		}
@@ -54,6 +62,9 @@ func main(cmd *cobra.Command, args []string) error {
 	}
 
 	w := watch.NewWatcher(log, subs...)
+	if conf != nil {
+		w.UseConfig(conf)
+	}
 	if pcap != "" {
 		return w.WatchPCAP(ctx, pcap)
 	}
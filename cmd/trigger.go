@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/henrywallace/netwatch/util"
+	"github.com/henrywallace/netwatch/watch"
+)
+
+var triggerCmd = &cobra.Command{
+	Use:   "trigger",
+	Short: "Work with config triggers",
+}
+
+var triggerTestCmd = &cobra.Command{
+	Use:   "test",
+	Short: "Show which triggers match an event, without running them",
+	Long: `Show which triggers match an event, and what each would run, without
+running them. The event is either synthesized from --event and the given host
+details, or all events from replaying --pcap, in which case the number of
+events each trigger matches is shown. Only onShell filters are run.`,
+	Args:          cobra.NoArgs,
+	RunE:          triggerTest,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
+
+func init() {
+	f := triggerTestCmd.Flags()
+	f.StringP("config", "c", "config.toml", "toml, yaml or json file to trigger config")
+	f.StringSlice("profile", nil, "config profiles to apply, in order")
+	f.StringSliceP("only", "o", nil, "config trigger names to only test")
+	f.StringP("event", "e", "", "type of event to synthesize, e.g. host.new")
+	f.String("mac", "00:00:5e:00:53:01", "MAC address of the event's host")
+	f.String("ip", "192.0.2.1", "IP address of the event's host")
+	f.Int("port", 0, "port number of the event, for port events")
+	f.Bool("udp", false, "whether the event's port is UDP rather than TCP")
+	f.StringP("pcap", "p", "", "pcap file to replay instead of synthesizing an event")
+	triggerCmd.AddCommand(triggerTestCmd)
+	rootCmd.AddCommand(triggerCmd)
+}
+
+func triggerTest(cmd *cobra.Command, args []string) error {
+	log := util.NewLogger()
+	path := mustString(log, cmd, "config")
+	profiles := mustStringSlice(log, cmd, "profile")
+	only := mustStringSlice(log, cmd, "only")
+	conf, err := watch.LoadConfig(path, profiles...)
+	if err != nil {
+		return err
+	}
+	tester, err := watch.NewTriggerTester(log, conf, only)
+	if err != nil {
+		return err
+	}
+
+	event := mustString(log, cmd, "event")
+	pcap := mustString(log, cmd, "pcap")
+	switch {
+	case event != "" && pcap != "":
+		return errors.Errorf(
+			"cannot specify both --event=%s and --pcap=%s",
+			event,
+			pcap,
+		)
+	case pcap != "":
+		return replayTriggers(log, tester, conf, pcap)
+	case event == "":
+		return errors.New("one of --event or --pcap is required")
+	}
+
+	var ty watch.EventType
+	if err := ty.UnmarshalText([]byte(event)); err != nil {
+		return err
+	}
+	ip := net.ParseIP(mustString(log, cmd, "ip"))
+	if ip == nil {
+		return errors.Errorf("invalid --ip=%s", mustString(log, cmd, "ip"))
+	}
+	mac, err := net.ParseMAC(mustString(log, cmd, "mac"))
+	if err != nil {
+		return errors.Wrap(err, "invalid --mac")
+	}
+	port, err := cmd.Flags().GetInt("port")
+	if err != nil {
+		return err
+	}
+	udp, err := cmd.Flags().GetBool("udp")
+	if err != nil {
+		return err
+	}
+	e, err := watch.NewTestEvent(ty, watch.MAC(mac.String()), ip, port, udp)
+	if err != nil {
+		return err
+	}
+
+	matches, err := tester.Test(e)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("event: %s\n", watch.Describe(e))
+	if len(matches) == 0 {
+		fmt.Println("no triggers match")
+		return nil
+	}
+	for _, m := range matches {
+		if m.Digest != "" {
+			fmt.Printf("%s: digest: %s\n", m.Trigger, m.Digest)
+		}
+		fmt.Printf("%s: %s\n", m.Trigger, m.Run)
+	}
+	return nil
+}
+
+// replayTriggers replays the given pcap file, and prints how many of its
+// events each trigger matches.
+func replayTriggers(
+	log *logrus.Logger,
+	tester *watch.TriggerTester,
+	conf *watch.Config,
+	pcap string,
+) error {
+	var total int
+	counts := make(map[string]int)
+	w := watch.NewWatcher(log, func(e watch.Event) error {
+		total++
+		for _, name := range tester.Match(e) {
+			counts[name]++
+		}
+		return nil
+	})
+	w.UseConfig(conf)
+	if err := w.WatchPCAP(context.Background(), pcap); err != nil {
+		return err
+	}
+
+	fmt.Printf("%d events\n", total)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, name := range tester.Names() {
+		fmt.Fprintf(tw, "%s\t%d\n", name, counts[name])
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,262 @@
+package watch
+
+import (
+	"bytes"
+	"net"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+// ARPConfig holds configuration for ARP based detection.
+type ARPConfig struct {
+	// Gateways are IPv4 addresses of the network's gateways. Any change of
+	// the MAC address claiming one of them raises an arp.spoof event, even
+	// if the previous MAC has since gone inactive.
+	Gateways []net.IP
+	Scan     ARPScanConfig
+}
+
+// Default thresholds of ARPScanConfig.
+const (
+	defaultARPScanWindow  = 10 * time.Second
+	defaultARPScanTargets = 20
+	defaultARPScanTTL     = 5 * time.Second
+)
+
+// ARPScanConfig holds the thresholds for detecting ARP scans. A host is ARP
+// scanning once it sends requests for at least Targets distinct IPv4
+// addresses within Window, and stops once it hasn't done so for TTL. Zero
+// values use defaults.
+//
+// Networks override these thresholds for hosts within them, where the first
+// network containing a host's IPv4 address applies.
+type ARPScanConfig struct {
+	Window   Duration
+	Targets  int
+	TTL      Duration
+	Networks []ARPScanNetwork
+}
+
+// ARPScanNetwork overrides the ARPScanConfig thresholds for hosts within a
+// network. Zero values use those of the ARPScanConfig.
+type ARPScanNetwork struct {
+	CIDR    CIDR
+	Window  Duration
+	Targets int
+	TTL     Duration
+}
+
+// forIP returns the thresholds for a host with the given IPv4 address.
+func (c ARPScanConfig) forIP(ip net.IP) ARPScanConfig {
+	for _, n := range c.Networks {
+		if ip == nil || !n.CIDR.Contains(ip) {
+			continue
+		}
+		if n.Window.Duration > 0 {
+			c.Window = n.Window
+		}
+		if n.Targets > 0 {
+			c.Targets = n.Targets
+		}
+		if n.TTL.Duration > 0 {
+			c.TTL = n.TTL
+		}
+		break
+	}
+	if c.Window.Duration <= 0 {
+		c.Window.Duration = defaultARPScanWindow
+	}
+	if c.Targets <= 0 {
+		c.Targets = defaultARPScanTargets
+	}
+	if c.TTL.Duration <= 0 {
+		c.TTL.Duration = defaultARPScanTTL
+	}
+	return c
+}
+
+// ARPScan describes an ARP scan performed by a host.
+type ARPScan struct {
+	// Targets is the number of distinct IPv4 addresses probed, from Low to
+	// High.
+	Targets int
+	Low     net.IP
+	High    net.IP
+	// Start and End are when the first and last probes were sent.
+	Start time.Time
+	End   time.Time
+
+	seen map[string]bool
+}
+
+// Duration returns the time between the first and last probes of the scan.
+func (s ARPScan) Duration() time.Duration {
+	return s.End.Sub(s.Start)
+}
+
+func (s *ARPScan) add(ip net.IP, now time.Time) {
+	key := ip.String()
+	if s.seen[key] {
+		s.End = now
+		return
+	}
+	s.seen[key] = true
+	s.Targets++
+	if s.Low == nil || bytes.Compare(ip, s.Low) < 0 {
+		s.Low = ip
+	}
+	if s.High == nil || bytes.Compare(ip, s.High) > 0 {
+		s.High = ip
+	}
+	if s.Start.IsZero() {
+		s.Start = now
+	}
+	s.End = now
+}
+
+// ARPBinding is a MAC address claiming an IPv4 address over ARP.
+type ARPBinding struct {
+	IP       net.IP
+	MAC      MAC
+	LastSeen time.Time
+}
+
+// updateARPBindings tracks which MAC address owns each IPv4 address, as
+// claimed by the sender of the ARP packet in the given ViewPair if any, and
+// raises an arp.spoof event if the claim looks malicious.
+//
+// A claim is suspicious when it rebinds an IP address still owned by another
+// active MAC with an unsolicited reply or gratuitous announcement, or when it
+// rebinds a gateway at all. A suspicious claim doesn't take the address from
+// an active owner, but is recorded apart from it, so that the event is raised
+// once per claimant rather than each time the two contend for the address. It
+// is raised again if the claimant goes quiet for as long as the given timing
+// lets its host.
+func (w *Watcher) updateARPBindings(
+	hosts map[MAC]*Host,
+	h *Host,
+	vp ViewPair,
+	t Timing,
+	now time.Time,
+) {
+	arp := vp.ARP
+	if arp == nil || len(arp.SourceProtAddress) != net.IPv4len {
+		return
+	}
+	ip := append(net.IP(nil), arp.SourceProtAddress...)
+	if ip.Equal(net.IPv4zero) {
+		// An ARP probe, which doesn't claim anything yet.
+		return
+	}
+	mac := MAC(net.HardwareAddr(arp.SourceHwAddress).String())
+	curr := &ARPBinding{IP: ip, MAC: mac, LastSeen: now}
+	key := ip.String()
+	prev, ok := w.arp[key]
+	if !ok || prev.MAC == mac {
+		w.arp[key] = curr
+		return
+	}
+
+	gratuitous := ip.Equal(net.IP(arp.DstProtAddress))
+	isReply := arp.Operation == layers.ARPReply
+	gateway := containsIP(w.conf.ARP.Gateways, ip)
+	owner, ok := hosts[prev.MAC]
+	ownerActive := ok && owner.Activity.IsActive
+	if !gateway && !(ownerActive && (gratuitous || isReply)) {
+		w.log.Debugf("%s rebound from %s to %s", ip, prev.MAC, mac)
+		w.arp[key] = curr
+		delete(w.arpClaims, key)
+		return
+	}
+	if !ownerActive {
+		// Only a gateway is suspicious without an active owner, which
+		// is rebound all the same, since the owner may well be gone.
+		w.arp[key] = curr
+		delete(w.arpClaims, key)
+	} else {
+		claim, ok := w.arpClaims[key]
+		w.arpClaims[key] = curr
+		if ok && claim.MAC == mac && now.Sub(claim.LastSeen) <= t.Host {
+			return
+		}
+	}
+	w.emit(Event{
+		Type: ARPSpoof,
+		Body: EventARPSpoof{
+			Host:    h,
+			Old:     *prev,
+			New:     *curr,
+			Gateway: gateway,
+		},
+	})
+}
+
+// arpWhoHas is the key under which ARP requests are counted in a probeWindow.
+const arpWhoHas = "who-has"
+
+// updateARPScan counts the ARP request sent by the given host in the given
+// packet, if any, and raises host.arp-scan.start once it has requested enough
+// distinct addresses. Replies and gratuitous announcements aren't counted.
+func (w *Watcher) updateARPScan(h *Host, vp ViewPair, now time.Time) {
+	arp := vp.ARP
+	if arp == nil || arp.Operation != layers.ARPRequest ||
+		len(arp.DstProtAddress) != net.IPv4len ||
+		bytes.Equal(arp.SourceProtAddress, arp.DstProtAddress) {
+		return
+	}
+	target := append(net.IP(nil), arp.DstProtAddress...)
+	c := w.conf.ARP.Scan.forIP(h.IPv4)
+	if h.arps == nil {
+		h.arps = newProbeWindow(c.Window.Duration)
+	}
+	h.arps.size = c.Window.Duration
+	h.arps.Add(probe{at: now, target: target.String(), port: arpWhoHas})
+	active := h.ActivityARPScan != nil && h.ActivityARPScan.IsActive
+	scanning := h.arps.Targets(arpWhoHas) >= c.Targets
+	if !active && !scanning {
+		return
+	}
+	// The scan is read by the expiry callback, outside of this goroutine.
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if h.ActivityARPScan == nil {
+		h.ActivityARPScan = newActivity(w.clock, c.TTL.Duration, func(a *Activity) {
+			w.mu.Lock()
+			scan := *h.ARPScan
+			up := a.Up()
+			w.mu.Unlock()
+			w.emit(Event{
+				Type: HostARPScanStop,
+				Body: EventHostARPScanStop{
+					Host:     h,
+					Up:       up,
+					Targets:  scan.Targets,
+					Low:      scan.Low,
+					High:     scan.High,
+					Duration: scan.Duration(),
+				},
+			})
+		})
+	}
+	if !active || h.ARPScan == nil {
+		// Include those targets requested before the scan was
+		// detected.
+		h.ARPScan = &ARPScan{seen: make(map[string]bool)}
+		for _, p := range h.arps.probes {
+			h.ARPScan.add(net.ParseIP(p.target).To4(), p.at)
+		}
+	} else {
+		h.ARPScan.add(target, now)
+	}
+	if !scanning {
+		return
+	}
+	h.ActivityARPScan.SetTTL(c.TTL.Duration)
+	if !h.ActivityARPScan.Touch(now) {
+		w.emit(Event{
+			Type: HostARPScanStart,
+			Body: EventHostARPScanStart{h},
+		})
+	}
+}
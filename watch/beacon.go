@@ -0,0 +1,234 @@
+package watch
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"time"
+)
+
+// Default thresholds of BeaconConfig.
+const (
+	defaultBeaconMinSamples    = 8
+	defaultBeaconMinConfidence = 0.8
+	defaultBeaconMinPeriod     = 2 * time.Second
+)
+
+// beaconMaxIntervals is the number of most recent intervals kept per flow.
+const beaconMaxIntervals = 32
+
+// beaconMaxIdle is how long a flow may go unseen before it is forgotten.
+const beaconMaxIdle = 6 * time.Hour
+
+// beaconUDPIdle is how long a UDP flow must have been idle for its next
+// packet to count as a new connection. UDP has no handshake, so without this
+// every packet of a long lived flow, e.g. QUIC or its keepalives, would be
+// counted as one.
+const beaconUDPIdle = time.Minute
+
+// BeaconConfig holds the thresholds for detecting beaconing, i.e. a host
+// connecting to the same remote port on a fixed interval. A flow is beaconing
+// once at least MinSamples intervals have been seen, and their periodicity
+// scores at least MinConfidence between 0 and 1. Connections less than
+// MinPeriod apart are counted as one, as are UDP packets less than a minute
+// apart. Zero values use defaults.
+type BeaconConfig struct {
+	MinSamples    int
+	MinConfidence float64
+	MinPeriod     Duration
+}
+
+func (c BeaconConfig) withDefaults() BeaconConfig {
+	if c.MinSamples <= 0 {
+		c.MinSamples = defaultBeaconMinSamples
+	}
+	if c.MinConfidence <= 0 {
+		c.MinConfidence = defaultBeaconMinConfidence
+	}
+	if c.MinPeriod.Duration <= 0 {
+		c.MinPeriod.Duration = defaultBeaconMinPeriod
+	}
+	return c
+}
+
+// Beacon describes a flow from a host to a remote port that recurs on a fixed
+// interval.
+type Beacon struct {
+	Remote Endpoint
+	// Protocol is either "tcp" or "udp".
+	Protocol string
+	// Period is the median interval between connections, and Jitter the
+	// median absolute deviation from it.
+	Period time.Duration
+	Jitter time.Duration
+	// Confidence is between 0 and 1, and Samples is the number of
+	// intervals it was scored from.
+	Confidence float64
+	Samples    int
+}
+
+func (b Beacon) String() string {
+	return fmt.Sprintf(
+		"beacon to %s/%s every %s ±%s (confidence %.2f)",
+		b.Remote,
+		b.Protocol,
+		b.Period.Round(time.Millisecond),
+		b.Jitter.Round(time.Millisecond),
+		b.Confidence,
+	)
+}
+
+// beaconFlow holds the recent intervals between connections of one flow, and
+// the period it was last reported with, if any.
+type beaconFlow struct {
+	last      time.Time
+	intervals []time.Duration
+	reported  time.Duration
+}
+
+// beaconFlows tracks the flows of all hosts.
+type beaconFlows struct {
+	flows map[string]*beaconFlow
+	adds  int
+}
+
+func newBeaconFlows() *beaconFlows {
+	return &beaconFlows{flows: make(map[string]*beaconFlow)}
+}
+
+// prune forgets flows that haven't been seen for a while.
+func (t *beaconFlows) prune(now time.Time) {
+	for key, f := range t.flows {
+		if now.Sub(f.last) > beaconMaxIdle {
+			delete(t.flows, key)
+		}
+	}
+}
+
+// scoreBeacon scores the periodicity of the given intervals, returning their
+// median, median absolute deviation, and a confidence between 0 and 1. Medians
+// are used so that the occasional missed or extra connection doesn't ruin an
+// otherwise regular flow.
+func scoreBeacon(intervals []time.Duration) (time.Duration, time.Duration, float64) {
+	period := median(intervals)
+	if period <= 0 {
+		return 0, 0, 0
+	}
+	devs := make([]time.Duration, len(intervals))
+	for i, d := range intervals {
+		devs[i] = d - period
+		if devs[i] < 0 {
+			devs[i] = -devs[i]
+		}
+	}
+	jitter := median(devs)
+	// The relative jitter is mapped to 1 when there is none, falling to 0
+	// when it is a quarter of the period or more.
+	conf := 1 - 4*float64(jitter)/float64(period)
+	return period, jitter, math.Max(0, conf)
+}
+
+// samePeriod returns whether the given periods are within a quarter of the
+// latter of each other, i.e. within the jitter a beacon may have.
+func samePeriod(period, reported time.Duration) bool {
+	diff := period - reported
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff < reported/4
+}
+
+func median(ds []time.Duration) time.Duration {
+	if len(ds) == 0 {
+		return 0
+	}
+	s := append([]time.Duration(nil), ds...)
+	sort.Slice(s, func(i, j int) bool { return s[i] < s[j] })
+	if len(s)%2 == 0 {
+		return (s[len(s)/2-1] + s[len(s)/2]) / 2
+	}
+	return s[len(s)/2]
+}
+
+// newFlowStart returns the remote endpoint and protocol of the connection
+// started by the given packet, if it starts one.
+func newFlowStart(vp ViewPair) (Endpoint, string, bool) {
+	dst := vp.Dst.IPv4
+	if dst == nil {
+		dst = vp.Dst.IPv6
+	}
+	if dst == nil || dst.IsMulticast() || dst.Equal(net.IPv4bcast) {
+		return Endpoint{}, "", false
+	}
+	switch {
+	case vp.TCP != nil:
+		if !vp.TCP.SYN || vp.TCP.ACK {
+			return Endpoint{}, "", false
+		}
+		return Endpoint{dst, int(vp.TCP.DstPort)}, "tcp", true
+	case vp.UDP != nil:
+		src, dstPort := int(vp.UDP.SrcPort), int(vp.UDP.DstPort)
+		if srcLooksLikeServer(src, dstPort) {
+			return Endpoint{}, "", false
+		}
+		return Endpoint{dst, dstPort}, "udp", true
+	}
+	return Endpoint{}, "", false
+}
+
+// updateBeacons records the connection started by the given host in the given
+// packet, if any, and raises flow.beacon once its flow recurs regularly
+// enough.
+func (w *Watcher) updateBeacons(h *Host, vp ViewPair, now time.Time) {
+	remote, proto, ok := newFlowStart(vp)
+	if !ok {
+		return
+	}
+	c := w.conf.Beacon.withDefaults()
+	key := fmt.Sprintf("%s|%s/%s", h.MAC, remote, proto)
+	f, ok := w.beacons.flows[key]
+	if !ok {
+		w.beacons.flows[key] = &beaconFlow{last: now}
+		w.beacons.adds++
+		if w.beacons.adds%1024 == 0 {
+			w.beacons.prune(now)
+		}
+		return
+	}
+	gap := now.Sub(f.last)
+	f.last = now
+	minGap := c.MinPeriod.Duration
+	if proto == "udp" && minGap < beaconUDPIdle {
+		minGap = beaconUDPIdle
+	}
+	if gap < minGap {
+		// Part of the same burst, e.g. a retransmitted SYN or the
+		// rest of a UDP flow, or a flow that recurs too often to be a
+		// beacon.
+		return
+	}
+	f.intervals = append(f.intervals, gap)
+	if len(f.intervals) > beaconMaxIntervals {
+		f.intervals = f.intervals[len(f.intervals)-beaconMaxIntervals:]
+	}
+	if len(f.intervals) < c.MinSamples {
+		return
+	}
+	period, jitter, conf := scoreBeacon(f.intervals)
+	if conf < c.MinConfidence || samePeriod(period, f.reported) {
+		return
+	}
+	f.reported = period
+	w.emit(Event{
+		Type: FlowBeacon,
+		Body: EventFlowBeacon{h, Beacon{
+			Remote:     remote,
+			Protocol:   proto,
+			Period:     period,
+			Jitter:     jitter,
+			Confidence: conf,
+			Samples:    len(f.intervals),
+		}},
+	})
+}
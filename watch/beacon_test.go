@@ -0,0 +1,166 @@
+package watch
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/sirupsen/logrus"
+)
+
+func TestScoreBeacon(t *testing.T) {
+	s := time.Second
+	tests := []struct {
+		name      string
+		intervals []time.Duration
+		period    time.Duration
+		jitter    time.Duration
+		conf      float64
+	}{
+		{"none", nil, 0, 0, 0},
+		{"regular", []time.Duration{60 * s, 60 * s, 60 * s, 60 * s}, 60 * s, 0, 1},
+		{
+			// An even number of intervals take the mean of the middle
+			// two as their median.
+			"jittery",
+			[]time.Duration{58 * s, 62 * s, 58 * s, 62 * s},
+			60 * s, 2 * s, 1 - 4*2.0/60,
+		},
+		{
+			// A missed connection and an extra one don't change the
+			// period of an otherwise regular flow.
+			"outliers",
+			[]time.Duration{60 * s, 120 * s, 60 * s, 5 * s, 60 * s},
+			60 * s, 0, 1,
+		},
+		{
+			"irregular",
+			[]time.Duration{10 * s, 50 * s, 200 * s, 30 * s, 90 * s},
+			50 * s, 40 * s, 0,
+		},
+	}
+	for _, tt := range tests {
+		period, jitter, conf := scoreBeacon(tt.intervals)
+		if period != tt.period || jitter != tt.jitter || conf != tt.conf {
+			t.Errorf("%s: scored %s ±%s (%.3f), want %s ±%s (%.3f)",
+				tt.name, period, jitter, conf, tt.period, tt.jitter, tt.conf)
+		}
+	}
+}
+
+// beaconPacket returns a packet from a client at 192.0.2.1 to 192.0.2.2 on
+// the given port, starting a TCP connection unless UDP is given.
+func beaconPacket(port int, udp bool) ViewPair {
+	vp := ViewPair{
+		Src: View{IPv4: net.IP{192, 0, 2, 1}},
+		Dst: View{IPv4: net.IP{192, 0, 2, 2}},
+	}
+	if udp {
+		vp.UDP = &layers.UDP{SrcPort: 50000, DstPort: layers.UDPPort(port)}
+	} else {
+		vp.TCP = &layers.TCP{SrcPort: 50000, DstPort: layers.TCPPort(port), SYN: true}
+	}
+	return vp
+}
+
+// beaconPeriods returns the periods of the beacons raised by a host sending
+// the given packets at the given times.
+func beaconPeriods(vp ViewPair, times []time.Duration) []time.Duration {
+	log := logrus.New()
+	log.Out = ioutil.Discard
+	w := NewWatcher(log)
+	w.events = make(chan Event, len(times))
+	h := &Host{MAC: "00:00:5e:00:53:01"}
+	t0 := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	for _, at := range times {
+		w.updateBeacons(h, vp, t0.Add(at))
+	}
+	close(w.events)
+	var periods []time.Duration
+	for e := range w.events {
+		periods = append(periods, e.Body.(EventFlowBeacon).Beacon.Period)
+	}
+	return periods
+}
+
+// every returns n times the given period apart, starting at the given offset.
+func every(start, period time.Duration, n int) []time.Duration {
+	times := make([]time.Duration, n)
+	for i := range times {
+		times[i] = start + time.Duration(i)*period
+	}
+	return times
+}
+
+func TestUpdateBeacons(t *testing.T) {
+	samples := defaultBeaconMinSamples + 1
+	tests := []struct {
+		name  string
+		vp    ViewPair
+		times []time.Duration
+		want  []time.Duration
+	}{
+		{
+			name:  "tcp",
+			vp:    beaconPacket(443, false),
+			times: every(0, 10*time.Second, samples),
+			want:  []time.Duration{10 * time.Second},
+		},
+		{
+			name:  "too few samples",
+			vp:    beaconPacket(443, false),
+			times: every(0, 10*time.Second, samples-1),
+		},
+		{
+			name:  "retransmitted syns",
+			vp:    beaconPacket(443, false),
+			times: append(every(0, 10*time.Second, samples-1), 70*time.Second+time.Second),
+		},
+		{
+			name: "reported again once the period changes",
+			vp:   beaconPacket(443, false),
+			times: append(
+				every(0, 10*time.Second, samples),
+				every(80*time.Second+time.Minute, time.Minute, 2*samples)...,
+			),
+			want: []time.Duration{10 * time.Second, time.Minute},
+		},
+		{
+			name: "but not when it only drifts",
+			vp:   beaconPacket(443, false),
+			times: append(
+				every(0, 10*time.Second, samples),
+				every(80*time.Second+11*time.Second, 11*time.Second, 2*samples)...,
+			),
+			want: []time.Duration{10 * time.Second},
+		},
+		{
+			// Packets of a UDP flow less than beaconUDPIdle apart are
+			// of the same flow, however regular.
+			name:  "udp keepalives",
+			vp:    beaconPacket(443, true),
+			times: every(0, 15*time.Second, 4*samples),
+		},
+		{
+			name:  "udp after an idle gap",
+			vp:    beaconPacket(123, true),
+			times: every(0, 2*time.Minute, samples),
+			want:  []time.Duration{2 * time.Minute},
+		},
+	}
+	for _, tt := range tests {
+		got := beaconPeriods(tt.vp, tt.times)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: raised beacons every %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: raised beacons every %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+}
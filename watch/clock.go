@@ -0,0 +1,123 @@
+package watch
+
+import (
+	"container/heap"
+	"time"
+)
+
+// clock schedules the expiry of Activities. Live capture uses the wall clock,
+// whereas a replayed capture uses the time of its packets, so that hosts,
+// ports and scans expire just as they would have live.
+type clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) expiryTimer
+	// advance moves the clock to the time of the latest packet, running
+	// any functions that are then due.
+	advance(now time.Time)
+}
+
+// expiryTimer is a function scheduled by a clock, as a time.Timer is.
+type expiryTimer interface {
+	Reset(d time.Duration) bool
+	Stop() bool
+}
+
+// wallClock runs functions after the given durations of real time.
+type wallClock struct{}
+
+func (wallClock) Now() time.Time {
+	return time.Now()
+}
+
+func (wallClock) AfterFunc(d time.Duration, f func()) expiryTimer {
+	return time.AfterFunc(d, f)
+}
+
+func (wallClock) advance(now time.Time) {}
+
+// replayClock runs functions once the given durations have passed between
+// packets. They're run by advance, on the goroutine scanning packets.
+type replayClock struct {
+	now    time.Time
+	timers replayTimers
+}
+
+func newReplayClock() *replayClock {
+	return new(replayClock)
+}
+
+func (c *replayClock) Now() time.Time {
+	return c.now
+}
+
+func (c *replayClock) AfterFunc(d time.Duration, f func()) expiryTimer {
+	t := &replayTimer{clock: c, f: f, index: -1}
+	t.Reset(d)
+	return t
+}
+
+func (c *replayClock) advance(now time.Time) {
+	if now.Before(c.now) {
+		return
+	}
+	c.now = now
+	for len(c.timers) > 0 && !c.timers[0].at.After(now) {
+		t := heap.Pop(&c.timers).(*replayTimer)
+		t.f()
+	}
+}
+
+// replayTimer is a function scheduled by a replayClock.
+type replayTimer struct {
+	clock *replayClock
+	at    time.Time
+	f     func()
+	// index is the position of this timer in its clock's heap, or -1 if it
+	// isn't scheduled.
+	index int
+}
+
+func (t *replayTimer) Reset(d time.Duration) bool {
+	t.at = t.clock.now.Add(d)
+	if t.index >= 0 {
+		heap.Fix(&t.clock.timers, t.index)
+		return true
+	}
+	heap.Push(&t.clock.timers, t)
+	return false
+}
+
+func (t *replayTimer) Stop() bool {
+	if t.index < 0 {
+		return false
+	}
+	heap.Remove(&t.clock.timers, t.index)
+	return true
+}
+
+// replayTimers is a heap of timers, soonest first.
+type replayTimers []*replayTimer
+
+func (h replayTimers) Len() int           { return len(h) }
+func (h replayTimers) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+
+func (h replayTimers) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *replayTimers) Push(x interface{}) {
+	t := x.(*replayTimer)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *replayTimers) Pop() interface{} {
+	old := *h
+	t := old[len(old)-1]
+	old[len(old)-1] = nil
+	t.index = -1
+	*h = old[:len(old)-1]
+	return t
+}
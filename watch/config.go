@@ -1,8 +1,120 @@
 package watch
 
-// Config holds configuration for Triggers.
+import (
+	"net"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Config holds configuration for Triggers, and for the policies that some
+// events are checked against.
 type Config struct {
 	Triggers map[string]TriggerSpec `toml:"triggers"`
+	RA       RAPolicy               `toml:"ra"`
+	ARP      ARPConfig              `toml:"arp"`
+	DHCP     DHCPPolicy             `toml:"dhcp"`
+	PortScan PortScanConfig         `toml:"portScan"`
+	ICMP     ICMPConfig             `toml:"icmp"`
+	Timing   TimingConfig           `toml:"timing"`
+	Beacon   BeaconConfig           `toml:"beacon"`
+
+	// Include are files to read beneath this one, as paths or patterns
+	// relative to it. And Profiles are overlays of this Config, applied over
+	// it when selected by name. Both are resolved by LoadConfig, so are
+	// always empty in the Configs it returns.
+	Include  []string          `toml:"include"`
+	Profiles map[string]Config `toml:"profiles"`
+
+	// sources are the files that were read, and the include patterns that
+	// were matched, which changes to would change this Config.
+	sources []string
+	// path and profiles are those it was loaded with, to reload it.
+	path     string
+	profiles []string
+}
+
+// samePolicies returns whether this Config has the same policies as the given
+// one, that is, whether they only differ by their triggers.
+func (c *Config) samePolicies(o *Config) bool {
+	a, b := *c, *o
+	a.Triggers, b.Triggers = nil, nil
+	a.sources, b.sources = nil, nil
+	return reflect.DeepEqual(a, b)
+}
+
+// LoadConfig reads a Config from the toml, yaml or json file at the given
+// path, by its extension, along with any files it includes. The given profiles
+// are then applied over it, in order. Any problems with it, such as unknown
+// keys, malformed values, or conflicting options, are returned together as
+// ConfigErrors.
+func LoadConfig(path string, profiles ...string) (*Config, error) {
+	var ld configLoader
+	layer, defined, err := ld.load(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(ld.errs) > 0 {
+		sortConfigErrors(ld.errs)
+		return nil, ld.errs
+	}
+	for _, name := range profiles {
+		p, ok := defined[name]
+		if !ok && len(defined) == 0 {
+			return nil, errors.Errorf("unknown profile %q, %s defines none", name, path)
+		}
+		if !ok {
+			return nil, errors.Errorf(
+				"unknown profile %q, expected one of %s",
+				name,
+				strings.Join(sortedLayerNames(defined), ", "),
+			)
+		}
+		layer.merge(p)
+	}
+
+	v := newConfigValidator(path, layer.pos)
+	var conf Config
+	if err := unmarshalData(path, layer.data, &conf); err != nil {
+		return nil, err
+	}
+	conf.validate(v)
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+	conf.sources = ld.sources
+	conf.path = path
+	conf.profiles = profiles
+	return &conf, nil
+}
+
+// parseConfigFile returns the loosely decoded data of the given config file,
+// and the position of each of its keys. Its data is checked against Config, so
+// that every problem can be found and located, rather than only the first
+// that a decoder stops at.
+func parseConfigFile(
+	path string,
+	text string,
+) (map[string]interface{}, map[string]configPos, error) {
+	decode, err := configDecoderOf(path)
+	if err != nil {
+		return nil, nil, ConfigErrors{{Path: path, Msg: err.Error()}}
+	}
+	data, lines, err := decode(path, text)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Editors find the schema of json files by their $schema key.
+	delete(data, "$schema")
+	pos := filePos(path, lines)
+	v := newConfigValidator(path, pos)
+	v.checkValue(nil, data, reflect.TypeOf(Config{}))
+	if err := v.err(); err != nil {
+		return nil, nil, err
+	}
+	return data, pos, nil
 }
 
 // TriggerSpec describes specification for one trigger.
@@ -12,6 +124,99 @@ type TriggerSpec struct {
 	OnEventsExcept []EventType
 	OnAny          bool
 	OnShell        string
-	DoBuiltin      string
-	DoShell        string
+	// OnExpr is an Expr that events must satisfy.
+	OnExpr    string
+	DoBuiltin string
+	DoShell   string
+
+	// RateLimit limits how often the trigger runs, allowing bursts of up to
+	// its count. Events with the same DedupKey, a template such as
+	// {{.Host.MAC}}-{{.PortString}}, are only run once per DedupWindow. And
+	// after each run, no events are run for the Cooldown.
+	RateLimit   Rate
+	DedupKey    string
+	DedupWindow Duration
+	Cooldown    Duration
+
+	// Digest is an interval to buffer events over, at the end of which a
+	// single Digest event summarizing them is run instead. Its Description
+	// is rendered by the DigestTemplate. The interval is of wall clock
+	// time, even when replaying a capture.
+	Digest         Duration
+	DigestTemplate string
+	// Timeout is how long onShell and doShell commands may run for, by
+	// default 2s and 30s respectively, since onShell blocks other triggers.
+	// And Workers is how many doShell commands, or enriched doBuiltins, may run
+	// at once.
+	Timeout Duration
+	Workers int
+
+	// Enrich are steps run in order before the trigger's action, adding to
+	// the data its templates are given, e.g. {{.Enrich.rdns}}.
+	Enrich []EnrichStep
+}
+
+// EnrichStep is one of a trigger's enrich steps, which is either a builtin
+// such as rdns, or a shell command that prints a JSON object.
+type EnrichStep struct {
+	Builtin string
+	Shell   string
+}
+
+// RAPolicy describes which IPv6 Router Advertisements are expected. Each list
+// that is given is checked on its own, so that, say, advertised prefixes can be
+// checked without listing every router.
+type RAPolicy struct {
+	AllowedRouters  []MAC
+	AllowedPrefixes []CIDR
+	AllowedRDNSS    []net.IP
+}
+
+// CIDR is an IP network, which can be decoded from text such as
+// 192.168.86.0/24.
+type CIDR struct {
+	net.IPNet
+}
+
+// UnmarshalText satisfies the encoding.TextUnmarshaler interface.
+func (c *CIDR) UnmarshalText(text []byte) error {
+	_, n, err := net.ParseCIDR(string(text))
+	if err != nil {
+		return err
+	}
+	c.IPNet = *n
+	return nil
+}
+
+// MarshalText satisfies the encoding.TextMarshaler interface.
+func (c CIDR) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// Covers returns whether the given network is entirely within this one.
+func (c CIDR) Covers(n net.IPNet) bool {
+	cones, _ := c.Mask.Size()
+	nones, nbits := n.Mask.Size()
+	_, cbits := c.Mask.Size()
+	return cbits == nbits && cones <= nones && c.Contains(n.IP)
+}
+
+// Duration is a time.Duration, which can be decoded from text such as 10s.
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalText satisfies the encoding.TextUnmarshaler interface.
+func (d *Duration) UnmarshalText(text []byte) error {
+	dur, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	d.Duration = dur
+	return nil
+}
+
+// MarshalText satisfies the encoding.TextMarshaler interface.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
 }
@@ -0,0 +1,188 @@
+package watch
+
+import (
+	"net"
+	"strings"
+	"time"
+)
+
+// ipUse is when a host used an IP address, and how long it may go unseen
+// before it is no longer considered to be using it.
+type ipUse struct {
+	ip    net.IP
+	first time.Time
+	last  time.Time
+	ttl   time.Duration
+}
+
+func (u *ipUse) active(now time.Time) bool {
+	return now.Sub(u.last) <= u.ttl
+}
+
+// ipClaim holds each MAC address recently seen using an IP address.
+type ipClaim struct {
+	owners map[MAC]*ipUse
+	// conflict is whether an ip.conflict event has been raised for the
+	// current owners, so that it is raised only once until resolved.
+	conflict bool
+}
+
+// ipClaims tracks which hosts use each IP address, to find conflicts between
+// them, along with the addresses that each host uses.
+type ipClaims struct {
+	claims map[string]*ipClaim
+	byHost map[MAC]map[string]*ipUse
+	adds   int
+}
+
+func newIPClaims() *ipClaims {
+	return &ipClaims{
+		claims: make(map[string]*ipClaim),
+		byHost: make(map[MAC]map[string]*ipUse),
+	}
+}
+
+// prune forgets addresses that haven't been used for a while.
+func (t *ipClaims) prune(now time.Time) {
+	for key, c := range t.claims {
+		for mac, u := range c.owners {
+			if !u.active(now) {
+				t.release(mac, key)
+			}
+		}
+	}
+}
+
+// release forgets that the host with the given MAC uses the given address.
+func (t *ipClaims) release(mac MAC, key string) {
+	if c, ok := t.claims[key]; ok {
+		delete(c.owners, mac)
+		if len(c.owners) == 0 {
+			delete(t.claims, key)
+		}
+	}
+	if uses, ok := t.byHost[mac]; ok {
+		delete(uses, key)
+		if len(uses) == 0 {
+			delete(t.byHost, mac)
+		}
+	}
+}
+
+// movedFrom returns whether the host with the given MAC, having used the given
+// address, only started using another address of the same family after it
+// last used it. Addresses that a host uses at once, such as those of a
+// multihomed host or IPv6 temporary addresses, overlap instead.
+func (t *ipClaims) movedFrom(mac MAC, u *ipUse) bool {
+	v4 := u.ip.To4() != nil
+	for _, other := range t.byHost[mac] {
+		if other == u || (other.ip.To4() != nil) != v4 {
+			continue
+		}
+		if other.first.After(u.last) {
+			return true
+		}
+	}
+	return false
+}
+
+// updateIPConflicts records each on-link IP address used by the given host,
+// as shown by the given view, and raises an ip.conflict event whenever
+// another active host is also using it. Addresses that the host only routes,
+// such as those of remote hosts behind a gateway, aren't its own.
+func (w *Watcher) updateIPConflicts(
+	hosts map[MAC]*Host,
+	h *Host,
+	v View,
+	t Timing,
+	now time.Time,
+) {
+	if v.IPv4 != nil && !v.IPv4.Equal(net.IPv4zero) && w.isOnLink(v.IPv4) {
+		w.claimIP(hosts, h, v.IPv4, t, now)
+	}
+	for _, ip := range w.ipv6Addrs(v) {
+		w.claimIP(hosts, h, ip, t, now)
+	}
+}
+
+// claimIP records that the given host, with the given timing, used the given
+// IP address.
+//
+// An address is only in conflict while both hosts are still using it, as per
+// their timing. A previous owner that has since gone inactive, or that moved
+// to another address, most likely had its DHCP lease expire before the
+// address was reassigned, which is logged rather than raised.
+func (w *Watcher) claimIP(
+	hosts map[MAC]*Host,
+	h *Host,
+	ip net.IP,
+	t Timing,
+	now time.Time,
+) {
+	key := ip.String()
+	c, ok := w.ips.claims[key]
+	if !ok {
+		c = &ipClaim{owners: make(map[MAC]*ipUse)}
+		w.ips.claims[key] = c
+		w.ips.adds++
+		if w.ips.adds%1024 == 0 {
+			w.ips.prune(now)
+		}
+	}
+	use, owned := c.owners[h.MAC]
+	for mac, u := range c.owners {
+		if mac == h.MAC {
+			continue
+		}
+		other, ok := hosts[mac]
+		if ok && other.Activity.IsActive && u.active(now) &&
+			!w.ips.movedFrom(mac, u) {
+			continue
+		}
+		w.ips.release(mac, key)
+		if !owned {
+			w.log.Infof("ip %s reassigned from %s to %s", ip, mac, h.MAC)
+		}
+	}
+	if !owned {
+		use = &ipUse{ip: copyIP(ip), first: now}
+		if w.ips.claims[key] == nil {
+			// Releasing the previous owners forgot the address.
+			w.ips.claims[key] = c
+		}
+		c.owners[h.MAC] = use
+		if w.ips.byHost[h.MAC] == nil {
+			w.ips.byHost[h.MAC] = make(map[string]*ipUse)
+		}
+		w.ips.byHost[h.MAC][key] = use
+	}
+	use.last = now
+	use.ttl = t.Host
+	if len(c.owners) == 1 {
+		c.conflict = false
+		return
+	}
+	if c.conflict {
+		return
+	}
+	c.conflict = true
+	var others []*Host
+	for mac := range c.owners {
+		if mac != h.MAC {
+			others = append(others, hosts[mac])
+		}
+	}
+	w.emit(Event{
+		Type: IPConflict,
+		Body: EventIPConflict{h, ip, others},
+	})
+}
+
+// hostList describes the given hosts, joined by commas.
+func hostList(hosts []*Host) string {
+	var parts []string
+	for _, h := range hosts {
+		parts = append(parts, h.String())
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,160 @@
+package watch
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+// DHCPPolicy describes which DHCP servers are expected, where AllowedServers
+// are server identifiers. Each list that is given is checked on its own, so
+// that, say, offered DNS servers can be checked without listing every server.
+type DHCPPolicy struct {
+	AllowedServers []net.IP
+	AllowedRouters []net.IP
+	AllowedDNS     []net.IP
+}
+
+// DHCPReply holds the contents of a DHCPOFFER or DHCPACK.
+type DHCPReply struct {
+	Type layers.DHCPMsgType
+	// ServerID is the server identifier option, which is the address of
+	// the server that sent the reply, even when relayed.
+	ServerID net.IP
+	// Offered is the address offered to, or acknowledged for, the client.
+	Offered net.IP
+	Routers []net.IP
+	DNS     []net.IP
+}
+
+// newDHCPReply returns the reply within the given packet, if it is an offer or
+// acknowledgement.
+func newDHCPReply(dhcp *layers.DHCPv4) (*DHCPReply, bool) {
+	if dhcp.Operation != layers.DHCPOpReply {
+		return nil, false
+	}
+	r := DHCPReply{Offered: copyIP(dhcp.YourClientIP)}
+	for _, opt := range dhcp.Options {
+		switch opt.Type {
+		case layers.DHCPOptMessageType:
+			if len(opt.Data) == 1 {
+				r.Type = layers.DHCPMsgType(opt.Data[0])
+			}
+		case layers.DHCPOptServerID:
+			if len(opt.Data) == net.IPv4len {
+				r.ServerID = copyIP(opt.Data)
+			}
+		case layers.DHCPOptRouter:
+			r.Routers = parseIPv4s(opt.Data)
+		case layers.DHCPOptDNS:
+			r.DNS = parseIPv4s(opt.Data)
+		}
+	}
+	if r.Type != layers.DHCPMsgTypeOffer && r.Type != layers.DHCPMsgTypeAck {
+		return nil, false
+	}
+	return &r, true
+}
+
+func parseIPv4s(b []byte) []net.IP {
+	var ips []net.IP
+	for ; len(b) >= net.IPv4len; b = b[net.IPv4len:] {
+		ips = append(ips, copyIP(b[:net.IPv4len]))
+	}
+	return ips
+}
+
+func copyIP(b []byte) net.IP {
+	return append(net.IP(nil), b...)
+}
+
+// DHCPServer is a DHCP server that has been seen answering clients.
+type DHCPServer struct {
+	ID  net.IP
+	MAC MAC
+	// Routers and DNS are those last offered by this server.
+	Routers   []net.IP
+	DNS       []net.IP
+	FirstSeen time.Time
+	LastSeen  time.Time
+
+	// reported are the reasons that dhcp.server.unauthorized has been
+	// raised for, so that it's raised once for each.
+	reported map[string]bool
+}
+
+func (s DHCPServer) String() string {
+	return fmt.Sprintf("DHCPServer(%s, %s)", s.ID, s.MAC)
+}
+
+// updateDHCPServers records the DHCP server that sent the reply in the given
+// view, if any, which is the given host or a relay in front of it.
+func (w *Watcher) updateDHCPServers(h *Host, v View, now time.Time) {
+	r := v.DHCP
+	if r == nil {
+		return
+	}
+	id := r.ServerID
+	if id == nil {
+		id = v.IPv4
+	}
+	key := fmt.Sprintf("%s|%s", id, h.MAC)
+	s, ok := w.dhcpServers[key]
+	if !ok {
+		s = &DHCPServer{
+			ID:        id,
+			MAC:       h.MAC,
+			FirstSeen: now,
+			reported:  make(map[string]bool),
+		}
+		w.dhcpServers[key] = s
+	}
+	s.Routers = r.Routers
+	s.DNS = r.DNS
+	s.LastSeen = now
+	if !ok {
+		w.emit(Event{
+			Type: DHCPServerNew,
+			Body: EventDHCPServerNew{h, *s},
+		})
+	}
+	var reasons []string
+	for _, reason := range w.conf.DHCP.check(id, r) {
+		if !s.reported[reason] {
+			s.reported[reason] = true
+			reasons = append(reasons, reason)
+		}
+	}
+	if len(reasons) > 0 {
+		w.emit(Event{
+			Type: DHCPServerUnauthorized,
+			Body: EventDHCPServerUnauthorized{h, *s, r, reasons},
+		})
+	}
+}
+
+// check returns each way in which the given reply, from the server with the
+// given identifier, breaks this policy.
+func (p DHCPPolicy) check(id net.IP, r *DHCPReply) []string {
+	var reasons []string
+	if len(p.AllowedServers) > 0 && !containsIP(p.AllowedServers, id) {
+		reasons = append(reasons, fmt.Sprintf("unlisted server %s", id))
+	}
+	if len(p.AllowedRouters) > 0 {
+		for _, ip := range r.Routers {
+			if !containsIP(p.AllowedRouters, ip) {
+				reasons = append(reasons, fmt.Sprintf("unexpected router %s", ip))
+			}
+		}
+	}
+	if len(p.AllowedDNS) > 0 {
+		for _, ip := range r.DNS {
+			if !containsIP(p.AllowedDNS, ip) {
+				reasons = append(reasons, fmt.Sprintf("unexpected dns %s", ip))
+			}
+		}
+	}
+	return reasons
+}
@@ -0,0 +1,174 @@
+package watch
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// maxDigestEvents is the most events whose descriptions are kept for a digest,
+// so that a busy interval doesn't buffer without bound.
+const maxDigestEvents = 100
+
+// DigestCount is the number of events of one type in a digest.
+type DigestCount struct {
+	Type EventType
+	N    int
+}
+
+// digestNouns are how events of each type are counted in a digest summary.
+// Any others are counted by their name.
+var digestNouns = map[EventType]string{
+	HostNew:                "new host",
+	HostLost:               "lost host",
+	HostFound:              "found host",
+	HostARPScanStart:       "ARP scan",
+	HostPortScanStart:      "port scan",
+	HostPingSweepStart:     "ping sweep",
+	HostTracerouteStart:    "traceroute",
+	HostOSChanged:          "OS change",
+	PortNew:                "new port",
+	PortLost:               "lost port",
+	PortFound:              "found port",
+	HTTPRequest:            "HTTP request",
+	RouterNew:              "new router",
+	IPv6RogueRA:            "rogue RA",
+	ARPSpoof:               "ARP spoof",
+	IPConflict:             "IP conflict",
+	DHCPServerNew:          "new DHCP server",
+	DHCPServerUnauthorized: "unauthorized DHCP server",
+	FlowBeacon:             "beacon",
+}
+
+func (c DigestCount) String() string {
+	noun, ok := digestNouns[c.Type]
+	if !ok {
+		return fmt.Sprintf("%d %s", c.N, eventName(c.Type))
+	}
+	if c.N != 1 {
+		noun += "s"
+	}
+	return fmt.Sprintf("%d %s", c.N, noun)
+}
+
+// digester buffers the events of a digest trigger, and passes a single
+// Digest event summarizing them to the trigger's Subscriber at the end of
+// each interval. Intervals are of wall clock time, even when replaying a
+// capture, so a digest's Start and End are too.
+type digester struct {
+	log      *logrus.Logger
+	name     string
+	interval time.Duration
+	tmpl     *template.Template
+	sub      Subscriber
+
+	mu     sync.Mutex
+	digest EventDigest
+	counts map[EventType]int
+}
+
+func newDigester(
+	log *logrus.Logger,
+	name string,
+	spec TriggerSpec,
+	sub Subscriber,
+) (*digester, error) {
+	text := spec.DigestTemplate
+	if text == "" {
+		text = "{{.Summary}}"
+	}
+	tmpl, err := template.New("").Parse(text)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to template parse digestTemplate: %s", text)
+	}
+	return &digester{
+		log:      log,
+		name:     name,
+		interval: spec.Digest.Duration,
+		tmpl:     tmpl,
+		sub:      sub,
+	}, nil
+}
+
+// Add buffers the given event for the next digest.
+func (d *digester) Add(e Event) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.digest.Start.IsZero() {
+		d.digest.Start = time.Now()
+		d.counts = make(map[EventType]int)
+	}
+	if _, ok := d.counts[e.Type]; !ok {
+		d.digest.Counts = append(d.digest.Counts, DigestCount{Type: e.Type})
+	}
+	d.counts[e.Type]++
+	if len(d.digest.Events) >= maxDigestEvents {
+		d.digest.Omitted++
+		return nil
+	}
+	d.digest.Events = append(d.digest.Events, newEventInfo(e).Description)
+	return nil
+}
+
+// run sends a digest at the end of each interval, until the given context is
+// done, when any events still buffered are sent.
+func (d *digester) run(ctx context.Context) {
+	tick := time.NewTicker(d.interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			d.flush()
+			return
+		case <-tick.C:
+			d.flush()
+		}
+	}
+}
+
+func (d *digester) flush() {
+	e, ok := d.take(time.Now())
+	if !ok {
+		return
+	}
+	if err := d.sub(e); err != nil {
+		d.log.WithError(err).Errorf("failed to execute sub: %s", d.name)
+	}
+}
+
+// take returns a Digest event summarizing the events buffered until the given
+// time, and clears them. It returns false if there are none.
+func (d *digester) take(end time.Time) (Event, bool) {
+	d.mu.Lock()
+	digest, counts := d.digest, d.counts
+	d.digest, d.counts = EventDigest{}, nil
+	d.mu.Unlock()
+	if len(digest.Events) == 0 {
+		return Event{}, false
+	}
+
+	digest.Trigger = d.name
+	digest.End = end
+	var summary []string
+	for i := range digest.Counts {
+		digest.Counts[i].N = counts[digest.Counts[i].Type]
+		summary = append(summary, digest.Counts[i].String())
+	}
+	digest.Summary = strings.Join(summary, ", ")
+	var buf bytes.Buffer
+	if err := d.tmpl.Execute(&buf, digest); err != nil {
+		d.log.WithError(err).Errorf("failed to execute digestTemplate of trigger %s", d.name)
+		digest.Description = digest.Summary
+	} else {
+		digest.Description = buf.String()
+	}
+
+	return Event{Type: Digest, Timestamp: digest.End, Body: digest}, true
+}
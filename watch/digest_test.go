@@ -0,0 +1,107 @@
+package watch
+
+import (
+	"io/ioutil"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// testDigester returns a digester of a trigger with the given template.
+func testDigester(t *testing.T, tmpl string) *digester {
+	log := logrus.New()
+	log.Out = ioutil.Discard
+	spec := TriggerSpec{Digest: Duration{time.Hour}, DigestTemplate: tmpl}
+	d, err := newDigester(log, "daily", spec, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// addEvents adds a test event of each of the given types to the digester.
+func addEvents(t *testing.T, d *digester, types ...EventType) {
+	for _, ty := range types {
+		e, err := NewTestEvent(ty, "00:00:5e:00:53:01", net.ParseIP("192.0.2.1"), 22, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Add(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDigesterTake(t *testing.T) {
+	d := testDigester(t, "{{.Trigger}}: {{.Summary}} ({{len .Events}})")
+	if _, ok := d.take(time.Now()); ok {
+		t.Errorf("took a digest without any events")
+	}
+
+	before := time.Now()
+	addEvents(t, d, PortNew, HostNew, PortNew, HostARPScanStart, PortNew)
+	end := time.Now()
+	e, ok := d.take(end)
+	if !ok {
+		t.Fatal("took no digest")
+	}
+	digest := e.Body.(EventDigest)
+	if e.Type != Digest || !e.Timestamp.Equal(end) || !digest.End.Equal(end) {
+		t.Errorf("took a %v event at %s ending %s, want a digest at %s",
+			e.Type, e.Timestamp, digest.End, end)
+	}
+	if digest.Start.Before(before) || digest.Start.After(end) {
+		t.Errorf("digest started at %s, want between %s and %s", digest.Start, before, end)
+	}
+	// Counts are in the order each type was first seen.
+	wantCounts := []DigestCount{{PortNew, 3}, {HostNew, 1}, {HostARPScanStart, 1}}
+	if !reflect.DeepEqual(digest.Counts, wantCounts) {
+		t.Errorf("counted %v, want %v", digest.Counts, wantCounts)
+	}
+	if want := "3 new ports, 1 new host, 1 ARP scan"; digest.Summary != want {
+		t.Errorf("summarized %q, want %q", digest.Summary, want)
+	}
+	if want := "daily: 3 new ports, 1 new host, 1 ARP scan (5)"; digest.Description != want {
+		t.Errorf("described %q, want %q", digest.Description, want)
+	}
+
+	// Taking a digest clears it.
+	if _, ok := d.take(time.Now()); ok {
+		t.Errorf("took a digest twice")
+	}
+	addEvents(t, d, HostLost)
+	e, _ = d.take(time.Now())
+	if got := e.Body.(EventDigest).Summary; got != "1 lost host" {
+		t.Errorf("summarized %q after taking a digest, want only the later events", got)
+	}
+}
+
+func TestDigesterOmitted(t *testing.T) {
+	d := testDigester(t, "")
+	types := make([]EventType, maxDigestEvents+3)
+	for i := range types {
+		types[i] = HostNew
+	}
+	addEvents(t, d, types...)
+	e, _ := d.take(time.Now())
+	digest := e.Body.(EventDigest)
+	if len(digest.Events) != maxDigestEvents || digest.Omitted != 3 {
+		t.Errorf("kept %d events and omitted %d, want %d and 3",
+			len(digest.Events), digest.Omitted, maxDigestEvents)
+	}
+	if want := "103 new hosts"; digest.Summary != want || digest.Description != want {
+		t.Errorf("summarized %q and described %q, want %q", digest.Summary, digest.Description, want)
+	}
+}
+
+func TestDigesterTemplateError(t *testing.T) {
+	d := testDigester(t, "{{.Nope}}")
+	addEvents(t, d, HostNew)
+	e, _ := d.take(time.Now())
+	if got := e.Body.(EventDigest).Description; got != "1 new host" {
+		t.Errorf("described %q after failing to render, want the summary", got)
+	}
+}
@@ -0,0 +1,147 @@
+package watch
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+// PortRole describes which end of a connection a Port was used on.
+type PortRole int
+
+// Roles of a Port.
+const (
+	// PortServer is a port that a host is listening on.
+	PortServer PortRole = iota
+	// PortClient is a port on a remote host that a host connected to.
+	PortClient
+)
+
+// Endpoint is an IP address and port number.
+type Endpoint struct {
+	IP   net.IP
+	Port int
+}
+
+func (e Endpoint) String() string {
+	return net.JoinHostPort(e.IP.String(), strconv.Itoa(e.Port))
+}
+
+// maxRemotePorts is the most remote ports that a host is tracked as having
+// connected to at once, so that a busy client can't grow them without bound.
+const maxRemotePorts = 256
+
+// ephemeralPortMin is the lowest port commonly assigned by operating systems
+// for outgoing connections. IANA suggests 49152, but Linux uses 32768.
+const ephemeralPortMin = 32768
+
+// connTable remembers which end of each TCP connection is the server, as
+// learned from handshakes, so that packets seen later in the connection can
+// be attributed correctly.
+type connTable struct {
+	servers map[string]connServer
+	adds    int
+	ttl     time.Duration
+}
+
+type connServer struct {
+	server   string
+	guessed  bool
+	lastSeen time.Time
+}
+
+func newConnTable() *connTable {
+	return &connTable{
+		servers: make(map[string]connServer),
+		ttl:     ttlStream,
+	}
+}
+
+// SrcIsServer returns whether the source of the given TCP packet, sent
+// between the given endpoints, is the server end of the connection.
+//
+// A SYN is sent by the client, and a SYN-ACK by the server. For connections
+// whose handshake wasn't seen, a guess is made from the port numbers.
+func (t *connTable) SrcIsServer(src, dst Endpoint, tcp *layers.TCP, now time.Time) bool {
+	s, d := src.String(), dst.String()
+	key := s + "|" + d
+	if d < s {
+		key = d + "|" + s
+	}
+	prev, ok := t.servers[key]
+	var curr connServer
+	switch {
+	case tcp.SYN && !tcp.ACK:
+		curr = connServer{server: d}
+	case tcp.SYN && tcp.ACK:
+		curr = connServer{server: s}
+	case ok:
+		curr = prev
+	default:
+		curr = connServer{server: d, guessed: true}
+		if srcLooksLikeServer(src.Port, dst.Port) {
+			curr.server = s
+		}
+	}
+	curr.lastSeen = now
+	t.servers[key] = curr
+	if !ok {
+		t.adds++
+		if t.adds%1024 == 0 {
+			t.prune(now)
+		}
+	}
+	return curr.server == s
+}
+
+// prune forgets connections that haven't been seen for a while.
+func (t *connTable) prune(now time.Time) {
+	for key, c := range t.servers {
+		if now.Sub(c.lastSeen) > t.ttl {
+			delete(t.servers, key)
+		}
+	}
+}
+
+// srcLooksLikeServer guesses whether the source port of a packet is the
+// server end of a connection, based only on the port numbers.
+func srcLooksLikeServer(src, dst int) bool {
+	// Well known ports are almost always servers.
+	if (src < 1024) != (dst < 1024) {
+		return src < 1024
+	}
+	// Ephemeral ports are almost always clients.
+	if (src >= ephemeralPortMin) != (dst >= ephemeralPortMin) {
+		return src < ephemeralPortMin
+	}
+	return src < dst
+}
+
+// inferTCPRoles decides, for the TCP layer of the given ViewPair if any,
+// whether the source is serving its port or connecting to the destination's.
+func (w *Watcher) inferTCPRoles(vp *ViewPair, now time.Time) {
+	if vp.TCP == nil {
+		return
+	}
+	srcIP, dstIP := vp.Src.IPv4, vp.Dst.IPv4
+	if srcIP == nil {
+		srcIP, dstIP = vp.Src.IPv6, vp.Dst.IPv6
+	}
+	if srcIP == nil || dstIP == nil {
+		return
+	}
+	src := Endpoint{srcIP, int(vp.TCP.SrcPort)}
+	dst := Endpoint{dstIP, int(vp.TCP.DstPort)}
+	if w.conns.SrcIsServer(src, dst, vp.TCP, now) {
+		if vp.TCP.RST {
+			// Most likely refusing a connection to a closed port.
+			return
+		}
+		vp.Src.TCP[src.Port] = true
+	} else {
+		vp.Src.TCPRemote[dst.Port] = dst
+		vp.Dst.TCP[dst.Port] = true
+	}
+}
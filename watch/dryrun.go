@@ -0,0 +1,250 @@
+package watch
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// TriggerTester checks which triggers of a config match events, and what
+// they would run, without running them. Only onShell filters are run, since
+// there's no other way to know whether they match. Rate limits, dedup and
+// cooldowns are ignored. A digest trigger is shown the digest of just the
+// event being tested. Enrich steps are run, so that commands are rendered with
+// what they add.
+type TriggerTester struct {
+	triggers []testTrigger
+}
+
+type testTrigger struct {
+	name     string
+	shouldDo func(e Event) bool
+	builtin  string
+	shell    *shellCommand
+	digest   *digester
+	enricher *enricher
+}
+
+// TriggerMatch is a trigger that matched an event, and what it would run.
+type TriggerMatch struct {
+	Trigger string
+	// Digest is the description of the digest event that a digest trigger
+	// would run for, or empty otherwise.
+	Digest string
+	// Run is the rendered doShell command, or the name of the doBuiltin
+	// Subscriber.
+	Run string
+}
+
+// NewTriggerTester returns a TriggerTester of the enabled triggers of the
+// given config, or only those named if any are given.
+func NewTriggerTester(
+	log *logrus.Logger,
+	conf *Config,
+	only []string,
+) (*TriggerTester, error) {
+	var t TriggerTester
+	onlySet := stringSet(only)
+	for _, name := range sortedTriggerNames(conf.Triggers) {
+		spec := conf.Triggers[name]
+		if len(onlySet) > 0 && !onlySet[name] {
+			continue
+		}
+		if spec.Disabled && !onlySet[name] {
+			continue
+		}
+		shouldDo, err := newShouldDo(log, name, spec)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to load trigger %s", name)
+		}
+		trig := testTrigger{name: name, shouldDo: shouldDo, builtin: spec.DoBuiltin}
+		if spec.DoShell != "" {
+			trig.shell, err = newShellCommand(name, spec.DoShell, spec)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to load trigger %s", name)
+			}
+		}
+		trig.enricher, err = newEnricher(log, name, spec)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to load trigger %s", name)
+		}
+		if spec.Digest.Duration > 0 {
+			trig.digest, err = newDigester(log, name, spec, nil)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to load trigger %s", name)
+			}
+		}
+		t.triggers = append(t.triggers, trig)
+	}
+	if len(t.triggers) == 0 {
+		return nil, errors.Errorf("no subscribers loaded from %s", conf.path)
+	}
+	return &t, nil
+}
+
+// Names returns the names of the triggers being tested, in order.
+func (t *TriggerTester) Names() []string {
+	var names []string
+	for _, trig := range t.triggers {
+		names = append(names, trig.name)
+	}
+	return names
+}
+
+// Match returns the names of the triggers that match the given event.
+func (t *TriggerTester) Match(e Event) []string {
+	var names []string
+	for _, trig := range t.triggers {
+		if trig.shouldDo(e) {
+			names = append(names, trig.name)
+		}
+	}
+	return names
+}
+
+// Test returns the triggers that match the given event, and what each would
+// run.
+func (t *TriggerTester) Test(e Event) ([]TriggerMatch, error) {
+	var matches []TriggerMatch
+	for _, trig := range t.triggers {
+		if !trig.shouldDo(e) {
+			continue
+		}
+		m := TriggerMatch{Trigger: trig.name, Run: "builtin " + strings.ToLower(trig.builtin)}
+		run := e
+		if trig.digest != nil {
+			if err := trig.digest.Add(e); err != nil {
+				return nil, errors.Wrapf(err, "failed to digest trigger %s", trig.name)
+			}
+			run, _ = trig.digest.take(time.Now())
+			m.Digest = newEventInfo(run).Description
+		}
+		if trig.enricher != nil {
+			run = trig.enricher.Enrich(run)
+		}
+		info := newEventInfo(run)
+		if trig.shell != nil {
+			script, err := trig.shell.render(info)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to render trigger %s", trig.name)
+			}
+			m.Run = script
+		}
+		matches = append(matches, m)
+	}
+	return matches, nil
+}
+
+// Describe returns the description of the given event.
+func Describe(e Event) string {
+	return newEventInfo(e).Description
+}
+
+// NewTestEvent returns an event of the given type for testing triggers. It is
+// about a host with the given MAC and IP address, and the given port if any,
+// where other details are left empty or filled with placeholders.
+func NewTestEvent(
+	ty EventType,
+	mac MAC,
+	ip net.IP,
+	port int,
+	udp bool,
+) (Event, error) {
+	now := time.Now()
+	h := NewHost(mac, now, func(*Host) {})
+	if ip.To4() != nil {
+		h.IPv4 = ip
+	} else if ip != nil {
+		h.IPv6 = ip
+	}
+	var p *Port
+	if port != 0 {
+		if udp {
+			p = NewPortUDP(port, now, func(*Port) {})
+		} else {
+			p = NewPortTCP(port, now, func(*Port) {})
+		}
+	}
+
+	e := Event{Type: ty, Timestamp: now}
+	switch ty {
+	case HostTouch:
+		e.Body = EventHostTouch{Host: h}
+	case HostNew:
+		e.Body = EventHostNew{Host: h}
+	case HostLost:
+		e.Body = EventHostLost{Host: h}
+	case HostFound:
+		e.Body = EventHostFound{Host: h}
+	case HostARPScanStart:
+		e.Body = EventHostARPScanStart{Host: h}
+	case HostARPScanStop:
+		e.Body = EventHostARPScanStop{Host: h}
+	case HostPortScanStart:
+		e.Body = EventHostPortScanStart{Host: h, Scan: PortScan{Type: PortScanSYN}}
+	case HostPortScanStop:
+		e.Body = EventHostPortScanStop{Host: h, Scan: PortScan{Type: PortScanSYN}}
+	case HostPingSweepStart:
+		e.Body = EventHostPingSweepStart{Host: h}
+	case HostPingSweepStop:
+		e.Body = EventHostPingSweepStop{Host: h}
+	case HostTracerouteStart:
+		e.Body = EventHostTracerouteStart{Host: h, Route: Traceroute{Target: ip}}
+	case HostTracerouteStop:
+		e.Body = EventHostTracerouteStop{Host: h, Route: Traceroute{Target: ip}}
+	case HostOSChanged:
+		e.Body = EventHostOSChanged{Host: h}
+	case PortTouch, PortNew, PortLost, PortFound:
+		if p == nil {
+			return Event{}, errors.Errorf("%s events need a port", eventName(ty))
+		}
+		switch ty {
+		case PortTouch:
+			e.Body = EventPortTouch{Host: h, Port: p}
+		case PortNew:
+			e.Body = EventPortNew{Host: h, Port: p}
+		case PortLost:
+			e.Body = EventPortLost{Host: h, Port: p}
+		case PortFound:
+			e.Body = EventPortFound{Host: h, Port: p}
+		}
+	case HTTPRequest:
+		e.Body = EventHTTPRequest{
+			Host:    h,
+			Request: HTTPTransaction{Method: "GET", Host: "example.com", Path: "/"},
+		}
+	case RouterNew:
+		h.RA = &RouterAdvertisement{}
+		e.Body = EventRouterNew{Host: h}
+	case IPv6RogueRA:
+		h.RA = &RouterAdvertisement{}
+		e.Body = EventIPv6RogueRA{Host: h, Src: ip, RA: h.RA}
+	case ARPSpoof:
+		e.Body = EventARPSpoof{
+			Host: h,
+			Old:  ARPBinding{IP: ip, LastSeen: now},
+			New:  ARPBinding{IP: ip, MAC: mac, LastSeen: now},
+		}
+	case IPConflict:
+		e.Body = EventIPConflict{Host: h, IP: ip}
+	case DHCPServerNew:
+		e.Body = EventDHCPServerNew{Host: h, Server: DHCPServer{ID: ip, MAC: mac}}
+	case DHCPServerUnauthorized:
+		e.Body = EventDHCPServerUnauthorized{
+			Host:   h,
+			Server: DHCPServer{ID: ip, MAC: mac},
+			Reply:  &DHCPReply{Type: layers.DHCPMsgTypeOffer, ServerID: ip},
+		}
+	case FlowBeacon:
+		e.Body = EventFlowBeacon{Host: h}
+	case Digest:
+		e.Body = EventDigest{Start: now, End: now}
+	default:
+		return Event{}, errors.Errorf("cannot test %s events", eventName(ty))
+	}
+	return e, nil
+}
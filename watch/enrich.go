@@ -0,0 +1,168 @@
+package watch
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// enrichBuiltins are the names of the steps that can be used for an enrich
+// builtin.
+var enrichBuiltins = []string{"rdns", "vendor"}
+
+func isEnrichBuiltin(builtin string) bool {
+	for _, b := range enrichBuiltins {
+		if strings.EqualFold(b, builtin) {
+			return true
+		}
+	}
+	return false
+}
+
+// enrichFunc returns data about the given event to add to its Enrich.
+type enrichFunc func(e Event) (map[string]interface{}, error)
+
+// enricher runs the enrich steps of a trigger.
+type enricher struct {
+	log   *logrus.Logger
+	name  string
+	steps []enrichFunc
+}
+
+// newEnricher returns an enricher for the given trigger, or nil if it has no
+// enrich steps.
+func newEnricher(
+	log *logrus.Logger,
+	name string,
+	spec TriggerSpec,
+) (*enricher, error) {
+	if len(spec.Enrich) == 0 {
+		return nil, nil
+	}
+	en := &enricher{log: log, name: name}
+	for _, step := range spec.Enrich {
+		var f enrichFunc
+		switch {
+		case step.Builtin != "":
+			var err error
+			f, err = newEnrichBuiltin(step.Builtin, spec)
+			if err != nil {
+				return nil, err
+			}
+		case step.Shell != "":
+			c, err := newShellCommand(name, step.Shell, spec)
+			if err != nil {
+				return nil, err
+			}
+			f = enrichFromShell(c)
+		default:
+			return nil, errors.New("enrich step needs one of builtin or shell")
+		}
+		en.steps = append(en.steps, f)
+	}
+	return en, nil
+}
+
+// Enrich returns the given event with the data of each step added to it.
+// Later steps see what earlier ones added, and may replace it. Steps that
+// fail are logged and skipped, so that the trigger still runs.
+func (en *enricher) Enrich(e Event) Event {
+	data := make(map[string]interface{}, len(e.Enrich))
+	for k, v := range e.Enrich {
+		data[k] = v
+	}
+	for i, step := range en.steps {
+		e.Enrich = data
+		add, err := step(e)
+		if err != nil {
+			en.log.WithError(err).Warnf(
+				"failed to run enrich step %d of trigger %s",
+				i,
+				en.name,
+			)
+			continue
+		}
+		for k, v := range add {
+			data[k] = v
+		}
+	}
+	e.Enrich = data
+	return e
+}
+
+func newEnrichBuiltin(builtin string, spec TriggerSpec) (enrichFunc, error) {
+	switch strings.ToLower(builtin) {
+	case "rdns":
+		timeout := spec.Timeout.Duration
+		if timeout == 0 {
+			timeout = defaultShellTimeout
+		}
+		return func(e Event) (map[string]interface{}, error) {
+			return enrichRDNS(e, timeout)
+		}, nil
+	case "vendor":
+		return enrichVendor, nil
+	default:
+		return nil, errors.Errorf("unknown enrich builtin: %s", builtin)
+	}
+}
+
+// enrichRDNS adds the name that the event's host's IP address reverse
+// resolves to, as rdns.
+func enrichRDNS(e Event, timeout time.Duration) (map[string]interface{}, error) {
+	h := newEventInfo(e).Host
+	ip := h.IPv4
+	if len(ip) == 0 {
+		ip = h.IPv6
+	}
+	if len(ip) == 0 {
+		return nil, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	names, err := net.DefaultResolver.LookupAddr(ctx, ip.String())
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+	return map[string]interface{}{
+		"rdns": strings.TrimSuffix(names[0], "."),
+	}, nil
+}
+
+// enrichVendor adds the vendor of the event's host's MAC address, from its
+// OUI, as vendor.
+func enrichVendor(e Event) (map[string]interface{}, error) {
+	h := newEventInfo(e).Host
+	vendor := h.Vendor
+	if vendor == "" {
+		vendor = lookupVendor(h.MAC)
+	}
+	if vendor == "" {
+		return nil, nil
+	}
+	return map[string]interface{}{"vendor": vendor}, nil
+}
+
+// enrichFromShell returns a step that runs the given command, which should
+// print a JSON object to add.
+func enrichFromShell(c *shellCommand) enrichFunc {
+	return func(e Event) (map[string]interface{}, error) {
+		b, err := c.output(e)
+		if err != nil {
+			return nil, err
+		}
+		var add map[string]interface{}
+		if err := json.Unmarshal(b, &add); err != nil {
+			return nil, errors.Wrapf(err, "expected a JSON object, got %q", b)
+		}
+		return add, nil
+	}
+}
@@ -2,6 +2,7 @@ package watch
 
 import (
 	"fmt"
+	"net"
 	"time"
 )
 
@@ -15,6 +16,18 @@ type Event struct {
 	Type      EventType
 	Timestamp time.Time
 	Body      interface{}
+	// Enrich is any data added by the enrich steps of the trigger that the
+	// event is being run for, keyed by name.
+	Enrich map[string]interface{}
+}
+
+// eventTime returns when the given event happened, or the current time if it
+// wasn't stamped.
+func eventTime(e Event) time.Time {
+	if e.Timestamp.IsZero() {
+		return time.Now()
+	}
+	return e.Timestamp
 }
 
 // EventType describes the type of Event that has occurred.
@@ -29,10 +42,26 @@ const (
 	HostFound
 	HostARPScanStart
 	HostARPScanStop
+	HostPortScanStart
+	HostPortScanStop
+	HostPingSweepStart
+	HostPingSweepStop
+	HostTracerouteStart
+	HostTracerouteStop
+	HostOSChanged
 	PortTouch
 	PortNew
 	PortLost
 	PortFound
+	HTTPRequest
+	RouterNew
+	IPv6RogueRA
+	ARPSpoof
+	IPConflict
+	DHCPServerNew
+	DHCPServerUnauthorized
+	FlowBeacon
+	Digest
 )
 
 // MarshalText satisfies the encoding.TextMarshaler interface.
@@ -53,6 +82,20 @@ func (ty EventType) MarshalText() ([]byte, error) {
 		s = "host.arp-scan.start"
 	case HostARPScanStop:
 		s = "host.arp-scan.stop"
+	case HostPortScanStart:
+		s = "host.port-scan.start"
+	case HostPortScanStop:
+		s = "host.port-scan.stop"
+	case HostPingSweepStart:
+		s = "host.ping-sweep.start"
+	case HostPingSweepStop:
+		s = "host.ping-sweep.stop"
+	case HostTracerouteStart:
+		s = "host.traceroute.start"
+	case HostTracerouteStop:
+		s = "host.traceroute.stop"
+	case HostOSChanged:
+		s = "host.os.changed"
 	case PortTouch:
 		s = "port.touch"
 	case PortNew:
@@ -61,6 +104,24 @@ func (ty EventType) MarshalText() ([]byte, error) {
 		s = "port.lost"
 	case PortFound:
 		s = "port.found"
+	case HTTPRequest:
+		s = "http.request"
+	case RouterNew:
+		s = "router.new"
+	case IPv6RogueRA:
+		s = "ipv6.rogue-ra"
+	case ARPSpoof:
+		s = "arp.spoof"
+	case IPConflict:
+		s = "ip.conflict"
+	case DHCPServerNew:
+		s = "dhcp.server.new"
+	case DHCPServerUnauthorized:
+		s = "dhcp.server.unauthorized"
+	case FlowBeacon:
+		s = "flow.beacon"
+	case Digest:
+		s = "digest"
 	default:
 		panic(fmt.Sprintf("unknown event type: %v", ty))
 	}
@@ -85,6 +146,20 @@ func (ty *EventType) UnmarshalText(text []byte) error {
 		*ty = HostARPScanStart
 	case "host.arp-scan.stop":
 		*ty = HostARPScanStop
+	case "host.port-scan.start":
+		*ty = HostPortScanStart
+	case "host.port-scan.stop":
+		*ty = HostPortScanStop
+	case "host.ping-sweep.start":
+		*ty = HostPingSweepStart
+	case "host.ping-sweep.stop":
+		*ty = HostPingSweepStop
+	case "host.traceroute.start":
+		*ty = HostTracerouteStart
+	case "host.traceroute.stop":
+		*ty = HostTracerouteStop
+	case "host.os.changed":
+		*ty = HostOSChanged
 	case "port.touch":
 		*ty = PortTouch
 	case "port.new":
@@ -93,12 +168,75 @@ func (ty *EventType) UnmarshalText(text []byte) error {
 		*ty = PortLost
 	case "port.found":
 		*ty = PortFound
+	case "http.request":
+		*ty = HTTPRequest
+	case "router.new":
+		*ty = RouterNew
+	case "ipv6.rogue-ra":
+		*ty = IPv6RogueRA
+	case "arp.spoof":
+		*ty = ARPSpoof
+	case "ip.conflict":
+		*ty = IPConflict
+	case "dhcp.server.new":
+		*ty = DHCPServerNew
+	case "dhcp.server.unauthorized":
+		*ty = DHCPServerUnauthorized
+	case "flow.beacon":
+		*ty = FlowBeacon
+	case "digest":
+		*ty = Digest
 	default:
-		panic(fmt.Sprintf("unknown event type: %v", ty))
+		return fmt.Errorf("unknown event type %q", text)
 	}
 	return nil
 }
 
+// Severity describes how concerning an Event is.
+type Severity int
+
+// Levels of Severity.
+const (
+	SeverityInfo Severity = iota
+	SeverityHigh
+)
+
+// MarshalText satisfies the encoding.TextMarshaler interface.
+func (sev Severity) MarshalText() ([]byte, error) {
+	switch sev {
+	case SeverityInfo:
+		return []byte("info"), nil
+	case SeverityHigh:
+		return []byte("high"), nil
+	default:
+		return nil, fmt.Errorf("unknown severity: %d", sev)
+	}
+}
+
+func (sev Severity) String() string {
+	b, err := sev.MarshalText()
+	if err != nil {
+		return err.Error()
+	}
+	return string(b)
+}
+
+// Severity returns how concerning events of this type are. Most events are
+// merely informational, whereas some indicate a possible attack.
+func (ty EventType) Severity() Severity {
+	switch ty {
+	case HostPortScanStart,
+		HostPingSweepStart,
+		IPv6RogueRA,
+		ARPSpoof,
+		IPConflict,
+		DHCPServerUnauthorized:
+		return SeverityHigh
+	default:
+		return SeverityInfo
+	}
+}
+
 //
 // host
 //
@@ -143,11 +281,70 @@ type EventHostARPScanStart struct {
 
 // EventHostARPScanStop indicates that a host has stopped performing an ARP
 // scan when it previously was.
+//
+// Targets is the number of distinct IPv4 addresses probed, from Low to High,
+// over the Duration between the first and last probe.
 type EventHostARPScanStop struct {
+	Host     *Host
+	Up       time.Duration
+	Targets  int
+	Low      net.IP
+	High     net.IP
+	Duration time.Duration
+}
+
+// EventHostPortScanStart indicates that a host has started a port scan, by
+// probing many ports on a target, or the same port on many targets, in a short
+// amount of time.
+type EventHostPortScanStart struct {
+	Host *Host
+	Scan PortScan
+}
+
+// EventHostPortScanStop indicates that a host has stopped performing a port
+// scan.
+type EventHostPortScanStop struct {
+	Host *Host
+	Scan PortScan
+	Up   time.Duration
+}
+
+// EventHostPingSweepStart indicates that a host has started a ping sweep, by
+// sending ICMP echo requests to many targets in a short amount of time.
+type EventHostPingSweepStart struct {
+	Host    *Host
+	Targets int
+}
+
+// EventHostPingSweepStop indicates that a host has stopped a ping sweep.
+type EventHostPingSweepStop struct {
 	Host *Host
 	Up   time.Duration
 }
 
+// EventHostTracerouteStart indicates that a host has started tracing the
+// route to a target, by sending it packets with incrementing TTLs.
+type EventHostTracerouteStart struct {
+	Host  *Host
+	Route Traceroute
+}
+
+// EventHostTracerouteStop indicates that a host has stopped tracing a route.
+// The Route holds each hop that answered.
+type EventHostTracerouteStop struct {
+	Host  *Host
+	Route Traceroute
+	Up    time.Duration
+}
+
+// EventHostOSChanged happens when the operating system inferred for a host,
+// from the TCP SYN and SYN-ACK packets it sends, changes. The new inference
+// and its confidence are on the Host.
+type EventHostOSChanged struct {
+	Host *Host
+	Prev string
+}
+
 //
 // port
 //
@@ -180,3 +377,120 @@ type EventPortFound struct {
 	Down time.Duration
 	Host *Host
 }
+
+//
+// router
+//
+
+// EventRouterNew happens when a host is first seen sending IPv6 Router
+// Advertisements. Its last advertisement is on the Host.
+type EventRouterNew struct {
+	Host *Host
+}
+
+// EventIPv6RogueRA happens when a Router Advertisement breaks the configured
+// RAPolicy, e.g. it was sent by an unlisted router, or advertises an
+// unexpected prefix. Reasons describes each way that it did so.
+type EventIPv6RogueRA struct {
+	Host    *Host
+	Src     net.IP
+	RA      *RouterAdvertisement
+	Reasons []string
+}
+
+//
+// arp
+//
+
+// EventARPSpoof happens when a host claims an IPv4 address over ARP that is
+// still owned by another active host, or that belongs to a gateway, which may
+// be an attempt to poison the ARP caches of other hosts.
+type EventARPSpoof struct {
+	Host    *Host
+	Old     ARPBinding
+	New     ARPBinding
+	Gateway bool
+}
+
+//
+// ip
+//
+
+// EventIPConflict happens when a host uses an IP address that other active
+// hosts, with distinct MAC addresses, are also using.
+type EventIPConflict struct {
+	Host   *Host
+	IP     net.IP
+	Others []*Host
+}
+
+//
+// dhcp
+//
+
+// EventDHCPServerNew happens when a DHCP server is first seen answering
+// clients. The Host is the server, or a relay in front of it.
+type EventDHCPServerNew struct {
+	Host   *Host
+	Server DHCPServer
+}
+
+// EventDHCPServerUnauthorized happens when a DHCP offer or acknowledgement
+// breaks the configured DHCPPolicy, e.g. it was sent by an unlisted server, or
+// offers an unexpected router. Reasons describes each way that it did so,
+// which it is only raised once for by each server.
+type EventDHCPServerUnauthorized struct {
+	Host    *Host
+	Server  DHCPServer
+	Reply   *DHCPReply
+	Reasons []string
+}
+
+//
+// flow
+//
+
+// EventFlowBeacon happens when a host is found connecting to the same remote
+// port on a fixed interval, as malware calling home often does.
+type EventFlowBeacon struct {
+	Host   *Host
+	Beacon Beacon
+}
+
+//
+// digest
+//
+
+// EventDigest happens at the end of each interval of a digest trigger, in
+// which it saw any events. It summarizes those events, instead of running
+// the trigger once for each.
+type EventDigest struct {
+	Trigger string
+	// Start and End are the wall clock times the digest's interval started
+	// and ended, even when replaying a capture.
+	Start time.Time
+	End   time.Time
+	// Counts are the number of each type of event, in the order they
+	// were first seen.
+	Counts []DigestCount
+	// Events are the descriptions of the first maxDigestEvents events, and
+	// Omitted the number of those after them, which are only counted.
+	Events  []string
+	Omitted int
+	// Summary is a short summary of the counts, such as 3 new hosts, 12
+	// new ports, 1 ARP scan. And Description is the digest rendered by
+	// the trigger's digestTemplate, which is the Summary by default.
+	Summary     string
+	Description string
+}
+
+//
+// http
+//
+
+// EventHTTPRequest happens for each plaintext HTTP request made by a host,
+// once its response has been seen or its connection has ended.
+type EventHTTPRequest struct {
+	Host    *Host
+	Request HTTPTransaction
+}
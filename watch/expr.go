@@ -0,0 +1,643 @@
+package watch
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// An Expr is a compiled onExpr filter, which decides in-process whether an
+// Event should trigger, for example:
+//
+//	type == "port.new" && port.num == 22 && host.ipv4 in cidr("192.168.86.0/24")
+//
+// Values are strings, numbers, booleans, IP addresses, networks and lists
+// such as ["host.new", "host.found"]. They are compared with ==, !=, <, <=, >
+// and >=, and combined with &&, || and !. The in operator checks whether an IP
+// address is within a network, a value is in a list, or a string is within
+// another. Fields are listed in exprFields. Values of the wrong type for their
+// operator, such as port.num > "a", are rejected when compiled.
+type Expr struct {
+	text string
+	eval exprFunc
+}
+
+type exprFunc func(env *exprEnv) interface{}
+
+// exprType is the type of the values that a subexpression evaluates to.
+type exprType int
+
+const (
+	// exprTypeAny is the type of the elements of empty or mixed lists.
+	exprTypeAny exprType = iota
+	exprTypeString
+	exprTypeNumber
+	exprTypeBool
+	exprTypeIP
+	exprTypeNet
+	exprTypeList
+)
+
+func (t exprType) String() string {
+	switch t {
+	case exprTypeString:
+		return "string"
+	case exprTypeNumber:
+		return "number"
+	case exprTypeBool:
+		return "boolean"
+	case exprTypeIP:
+		return "IP address"
+	case exprTypeNet:
+		return "network"
+	case exprTypeList:
+		return "list"
+	}
+	return "any"
+}
+
+// exprValue is a compiled subexpression, along with its type, and that of its
+// elements if it's a list.
+type exprValue struct {
+	typ  exprType
+	elem exprType
+	eval exprFunc
+}
+
+// exprField is a field of an Event that an Expr may refer to.
+type exprField struct {
+	typ  exprType
+	eval exprFunc
+}
+
+// exprEnv is what an Expr is evaluated against.
+type exprEnv struct {
+	e    Event
+	info printableEvent
+}
+
+// exprFields are the fields of an Event that an Expr may refer to.
+var exprFields = map[string]exprField{
+	"type":        {exprTypeString, func(env *exprEnv) interface{} { return eventName(env.e.Type) }},
+	"severity":    {exprTypeString, func(env *exprEnv) interface{} { return env.info.Severity }},
+	"description": {exprTypeString, func(env *exprEnv) interface{} { return env.info.Description }},
+
+	"host.mac":      {exprTypeString, func(env *exprEnv) interface{} { return string(env.info.Host.MAC) }},
+	"host.vendor":   {exprTypeString, func(env *exprEnv) interface{} { return env.info.Host.Vendor }},
+	"host.hostname": {exprTypeString, func(env *exprEnv) interface{} { return env.info.Host.Hostname }},
+	"host.os":       {exprTypeString, func(env *exprEnv) interface{} { return env.info.Host.OS }},
+	"host.ipv4":     {exprTypeIP, func(env *exprEnv) interface{} { return exprIP(env.info.Host.IPv4) }},
+	"host.ipv6":     {exprTypeIP, func(env *exprEnv) interface{} { return exprIP(env.info.Host.IPv6) }},
+
+	"port.num":    {exprTypeNumber, func(env *exprEnv) interface{} { return float64(env.info.Port.Num) }},
+	"port.proto":  {exprTypeString, func(env *exprEnv) interface{} { return portProto(env.info.Port) }},
+	"port.client": {exprTypeBool, func(env *exprEnv) interface{} { return env.info.Port.Role == PortClient }},
+
+	"http.method":    {exprTypeString, func(env *exprEnv) interface{} { return env.info.HTTP.Method }},
+	"http.host":      {exprTypeString, func(env *exprEnv) interface{} { return env.info.HTTP.Host }},
+	"http.path":      {exprTypeString, func(env *exprEnv) interface{} { return env.info.HTTP.Path }},
+	"http.userAgent": {exprTypeString, func(env *exprEnv) interface{} { return env.info.HTTP.UserAgent }},
+	"http.status":    {exprTypeNumber, func(env *exprEnv) interface{} { return float64(env.info.HTTP.Status) }},
+
+	// Durations are in seconds.
+	"up":   {exprTypeNumber, func(env *exprEnv) interface{} { return env.info.Up.Seconds() }},
+	"down": {exprTypeNumber, func(env *exprEnv) interface{} { return env.info.Down.Seconds() }},
+	"age":  {exprTypeNumber, func(env *exprEnv) interface{} { return env.info.Age.Seconds() }},
+}
+
+func eventName(ty EventType) string {
+	b, err := ty.MarshalText()
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// exprIP returns the given IP address, or nil rather than an empty one, so
+// that it compares equal to nothing.
+func exprIP(ip net.IP) interface{} {
+	if len(ip) == 0 {
+		return nil
+	}
+	return ip
+}
+
+// CompileExpr compiles the given filter expression.
+func CompileExpr(text string) (*Expr, error) {
+	toks, err := lexExpr(text)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{toks: toks}
+	start := p.peek()
+	x, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.errorf(t, "unexpected %s", t)
+	}
+	if x.typ != exprTypeBool {
+		return nil, p.errorf(start, "expected a boolean expression but found type %s", x.typ)
+	}
+	return &Expr{text: text, eval: x.eval}, nil
+}
+
+// Match returns whether the given Event satisfies this Expr.
+func (x *Expr) Match(e Event) bool {
+	env := &exprEnv{e: e, info: newEventInfo(e)}
+	b, _ := x.eval(env).(bool)
+	return b
+}
+
+func (x *Expr) String() string {
+	return x.text
+}
+
+//
+// lexing
+//
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokOp
+)
+
+type exprToken struct {
+	kind tokKind
+	text string
+	// col is the 1-based column that the token starts at.
+	col int
+	num float64
+}
+
+func (t exprToken) String() string {
+	if t.kind == tokEOF {
+		return "end of expression"
+	}
+	return strconv.Quote(t.text)
+}
+
+// exprOps are the operators, longest first so that they're matched greedily.
+var exprOps = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", "[", "]", ","}
+
+func lexExpr(text string) ([]exprToken, error) {
+	var toks []exprToken
+	rs := []rune(text)
+	i := 0
+outer:
+	for i < len(rs) {
+		r := rs[i]
+		col := i + 1
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case unicode.IsLetter(r) || r == '_':
+			j := i
+			for j < len(rs) && (unicode.IsLetter(rs[j]) || unicode.IsDigit(rs[j]) || rs[j] == '_' || rs[j] == '.') {
+				j++
+			}
+			toks = append(toks, exprToken{kind: tokIdent, text: string(rs[i:j]), col: col})
+			i = j
+		case unicode.IsDigit(r):
+			j := i
+			for j < len(rs) && (unicode.IsDigit(rs[j]) || rs[j] == '.') {
+				j++
+			}
+			n, err := strconv.ParseFloat(string(rs[i:j]), 64)
+			if err != nil {
+				return nil, errors.Errorf("column %d: invalid number %q", col, string(rs[i:j]))
+			}
+			toks = append(toks, exprToken{kind: tokNumber, text: string(rs[i:j]), col: col, num: n})
+			i = j
+		case r == '"' || r == '\'':
+			j := i + 1
+			var b strings.Builder
+			for ; j < len(rs) && rs[j] != r; j++ {
+				if rs[j] == '\\' && j+1 < len(rs) {
+					j++
+				}
+				b.WriteRune(rs[j])
+			}
+			if j >= len(rs) {
+				return nil, errors.Errorf("column %d: unterminated string", col)
+			}
+			toks = append(toks, exprToken{kind: tokString, text: b.String(), col: col})
+			i = j + 1
+		default:
+			for _, op := range exprOps {
+				if strings.HasPrefix(string(rs[i:]), op) {
+					toks = append(toks, exprToken{kind: tokOp, text: op, col: col})
+					i += len([]rune(op))
+					continue outer
+				}
+			}
+			return nil, errors.Errorf("column %d: unexpected %q", col, string(r))
+		}
+	}
+	return append(toks, exprToken{kind: tokEOF, col: len(rs) + 1}), nil
+}
+
+//
+// parsing
+//
+
+// exprParser compiles tokens into an exprFunc by recursive descent, where
+// precedence from lowest to highest is ||, &&, !, then comparisons.
+type exprParser struct {
+	toks []exprToken
+	pos  int
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.toks[p.pos]
+}
+
+func (p *exprParser) next() exprToken {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next token if it's the given operator or keyword.
+func (p *exprParser) accept(text string) bool {
+	t := p.peek()
+	if (t.kind == tokOp || t.kind == tokIdent) && t.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) expect(text string) error {
+	if !p.accept(text) {
+		t := p.peek()
+		return p.errorf(t, "expected %q but found %s", text, t)
+	}
+	return nil
+}
+
+func (p *exprParser) errorf(t exprToken, format string, args ...interface{}) error {
+	return errors.Errorf("column %d: %s", t.col, fmt.Sprintf(format, args...))
+}
+
+func (p *exprParser) parseOr() (exprValue, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return exprValue{}, err
+	}
+	for {
+		op := p.peek()
+		if !p.accept("||") {
+			return left, nil
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return exprValue{}, err
+		}
+		if err := p.checkBools(op, left, right); err != nil {
+			return exprValue{}, err
+		}
+		l, r := left.eval, right.eval
+		left = exprValue{typ: exprTypeBool, eval: func(env *exprEnv) interface{} {
+			return truthy(l(env)) || truthy(r(env))
+		}}
+	}
+}
+
+func (p *exprParser) parseAnd() (exprValue, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return exprValue{}, err
+	}
+	for {
+		op := p.peek()
+		if !p.accept("&&") {
+			return left, nil
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return exprValue{}, err
+		}
+		if err := p.checkBools(op, left, right); err != nil {
+			return exprValue{}, err
+		}
+		l, r := left.eval, right.eval
+		left = exprValue{typ: exprTypeBool, eval: func(env *exprEnv) interface{} {
+			return truthy(l(env)) && truthy(r(env))
+		}}
+	}
+}
+
+func (p *exprParser) parseNot() (exprValue, error) {
+	op := p.peek()
+	if p.accept("!") {
+		x, err := p.parseNot()
+		if err != nil {
+			return exprValue{}, err
+		}
+		if err := p.checkBools(op, x); err != nil {
+			return exprValue{}, err
+		}
+		return exprValue{typ: exprTypeBool, eval: func(env *exprEnv) interface{} {
+			return !truthy(x.eval(env))
+		}}, nil
+	}
+	return p.parseCompare()
+}
+
+// checkBools returns an error if any of the operands of the given logical
+// operator aren't booleans.
+func (p *exprParser) checkBools(op exprToken, xs ...exprValue) error {
+	for _, x := range xs {
+		if x.typ != exprTypeBool {
+			return p.errorf(op, "%s expects booleans but found type %s", op, x.typ)
+		}
+	}
+	return nil
+}
+
+func (p *exprParser) parseCompare() (exprValue, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return exprValue{}, err
+	}
+	t := p.peek()
+	var cmp func(a, b interface{}) bool
+	switch {
+	case t.kind == tokOp && t.text == "==":
+		cmp = equalValues
+	case t.kind == tokOp && t.text == "!=":
+		cmp = func(a, b interface{}) bool { return !equalValues(a, b) }
+	case t.kind == tokOp && (t.text == "<" || t.text == "<=" || t.text == ">" || t.text == ">="):
+		op := t.text
+		cmp = func(a, b interface{}) bool { return orderValues(op, a, b) }
+	case t.kind == tokIdent && t.text == "in":
+		cmp = inValues
+	default:
+		return left, nil
+	}
+	p.next()
+	right, err := p.parsePrimary()
+	if err != nil {
+		return exprValue{}, err
+	}
+	if err := p.checkCompare(t, left, right); err != nil {
+		return exprValue{}, err
+	}
+	l, r := left.eval, right.eval
+	return exprValue{typ: exprTypeBool, eval: func(env *exprEnv) interface{} {
+		return cmp(l(env), r(env))
+	}}, nil
+}
+
+// checkCompare returns an error if the given operands can't be compared by
+// the given operator, since the comparison would always be false.
+func (p *exprParser) checkCompare(op exprToken, left, right exprValue) error {
+	var ok bool
+	switch op.text {
+	case "==", "!=":
+		ok = equalTypes(left.typ, right.typ)
+	case "in":
+		switch right.typ {
+		case exprTypeNet:
+			ok = left.typ == exprTypeIP
+		case exprTypeList:
+			ok = equalTypes(left.typ, right.elem)
+		case exprTypeString:
+			ok = left.typ == exprTypeString
+		}
+	default:
+		ok = left.typ == right.typ &&
+			(left.typ == exprTypeNumber || left.typ == exprTypeString)
+	}
+	if !ok {
+		if op.text == "in" && right.typ == exprTypeList {
+			return p.errorf(op, "cannot compare type %s with list elements of type %s using in", left.typ, right.elem)
+		}
+		return p.errorf(op, "cannot compare types %s and %s using %s", left.typ, right.typ, op.text)
+	}
+	return nil
+}
+
+// equalTypes returns whether values of the given types can be equal, where
+// strings are compared to IP addresses by parsing them.
+func equalTypes(a, b exprType) bool {
+	switch {
+	case a == exprTypeAny || b == exprTypeAny:
+		return true
+	case a == exprTypeList || b == exprTypeList:
+		return false
+	case a == b:
+		return true
+	}
+	return (a == exprTypeIP && b == exprTypeString) || (a == exprTypeString && b == exprTypeIP)
+}
+
+func (p *exprParser) parsePrimary() (exprValue, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		return constant(exprTypeNumber, t.num), nil
+	case tokString:
+		return constant(exprTypeString, t.text), nil
+	case tokOp:
+		switch t.text {
+		case "(":
+			x, err := p.parseOr()
+			if err != nil {
+				return exprValue{}, err
+			}
+			return x, p.expect(")")
+		case "[":
+			return p.parseList()
+		}
+	case tokIdent:
+		switch t.text {
+		case "true":
+			return constant(exprTypeBool, true), nil
+		case "false":
+			return constant(exprTypeBool, false), nil
+		}
+		if p.accept("(") {
+			return p.parseCall(t)
+		}
+		if f, ok := exprFields[t.text]; ok {
+			return exprValue{typ: f.typ, eval: f.eval}, nil
+		}
+		return exprValue{}, p.errorf(t, "unknown field %s", t)
+	}
+	return exprValue{}, p.errorf(t, "unexpected %s", t)
+}
+
+func (p *exprParser) parseList() (exprValue, error) {
+	var elems []exprFunc
+	var elem exprType
+	for !p.accept("]") {
+		if len(elems) > 0 {
+			if err := p.expect(","); err != nil {
+				return exprValue{}, err
+			}
+		}
+		x, err := p.parsePrimary()
+		if err != nil {
+			return exprValue{}, err
+		}
+		if len(elems) == 0 {
+			elem = x.typ
+		} else if x.typ != elem {
+			elem = exprTypeAny
+		}
+		elems = append(elems, x.eval)
+	}
+	return exprValue{typ: exprTypeList, elem: elem, eval: func(env *exprEnv) interface{} {
+		vals := make([]interface{}, len(elems))
+		for i, x := range elems {
+			vals[i] = x(env)
+		}
+		return vals
+	}}, nil
+}
+
+// parseCall parses a call of the function with the given name, whose
+// arguments must be literals, so that they can be checked once when compiled.
+func (p *exprParser) parseCall(name exprToken) (exprValue, error) {
+	arg := p.next()
+	if arg.kind != tokString {
+		return exprValue{}, p.errorf(arg, "%s expects a string but found %s", name.text, arg)
+	}
+	if err := p.expect(")"); err != nil {
+		return exprValue{}, err
+	}
+	switch name.text {
+	case "cidr":
+		_, n, err := net.ParseCIDR(arg.text)
+		if err != nil {
+			return exprValue{}, p.errorf(arg, "%v", err)
+		}
+		return constant(exprTypeNet, n), nil
+	case "ip":
+		ip := net.ParseIP(arg.text)
+		if ip == nil {
+			return exprValue{}, p.errorf(arg, "invalid IP address %s", arg)
+		}
+		return constant(exprTypeIP, ip), nil
+	}
+	return exprValue{}, p.errorf(name, "unknown function %s", name)
+}
+
+func constant(typ exprType, v interface{}) exprValue {
+	return exprValue{typ: typ, eval: func(*exprEnv) interface{} { return v }}
+}
+
+//
+// evaluation
+//
+
+func truthy(v interface{}) bool {
+	b, _ := v.(bool)
+	return b
+}
+
+// equalValues returns whether the given values are equal, where strings are
+// compared to IP addresses by parsing them.
+func equalValues(a, b interface{}) bool {
+	switch a := a.(type) {
+	case net.IP:
+		ip := toIP(b)
+		return ip != nil && a.Equal(ip)
+	case string:
+		if ip, ok := b.(net.IP); ok {
+			return equalValues(ip, a)
+		}
+		s, ok := b.(string)
+		return ok && a == s
+	case float64:
+		n, ok := b.(float64)
+		return ok && a == n
+	case bool:
+		v, ok := b.(bool)
+		return ok && a == v
+	case *net.IPNet:
+		n, ok := b.(*net.IPNet)
+		return ok && a.String() == n.String()
+	}
+	return false
+}
+
+func orderValues(op string, a, b interface{}) bool {
+	var c int
+	switch a := a.(type) {
+	case float64:
+		n, ok := b.(float64)
+		if !ok {
+			return false
+		}
+		switch {
+		case a < n:
+			c = -1
+		case a > n:
+			c = 1
+		}
+	case string:
+		s, ok := b.(string)
+		if !ok {
+			return false
+		}
+		c = strings.Compare(a, s)
+	default:
+		return false
+	}
+	switch op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
+
+// inValues returns whether a is an IP address within the network b, equal to
+// a value of the list b, or a string within the string b.
+func inValues(a, b interface{}) bool {
+	switch b := b.(type) {
+	case *net.IPNet:
+		ip := toIP(a)
+		return ip != nil && b.Contains(ip)
+	case []interface{}:
+		for _, v := range b {
+			if equalValues(a, v) {
+				return true
+			}
+		}
+	case string:
+		s, ok := a.(string)
+		return ok && strings.Contains(b, s)
+	}
+	return false
+}
+
+func toIP(v interface{}) net.IP {
+	switch v := v.(type) {
+	case net.IP:
+		return v
+	case string:
+		return net.ParseIP(v)
+	}
+	return nil
+}
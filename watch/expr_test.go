@@ -0,0 +1,73 @@
+package watch
+
+import (
+	"net"
+	"testing"
+)
+
+func TestCompileExprErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		err  string
+	}{
+		{`port.num > "a"`, "column 10: cannot compare types number and string using >"},
+		{`type in cidr("10.0.0.0/8")`, "column 6: cannot compare types string and network using in"},
+		{`port.num in ["a", "b"]`, "column 10: cannot compare type number with list elements of type string using in"},
+		{`host.ipv4 == 1`, "column 11: cannot compare types IP address and number using =="},
+		{`port.client < true`, "column 13: cannot compare types boolean and boolean using <"},
+		{`port.num && true`, "column 10: \"&&\" expects booleans but found type number"},
+		{`!type`, "column 1: \"!\" expects booleans but found type string"},
+		{`port.num`, "column 1: expected a boolean expression but found type number"},
+		{`host.nope == 1`, "column 1: unknown field \"host.nope\""},
+		{`type == `, "column 9: unexpected end of expression"},
+		{`host.ipv4 in cidr("nope")`, "column 19: invalid CIDR address: nope"},
+		{`nope("a")`, "column 1: unknown function \"nope\""},
+		{`type == "a`, "column 9: unterminated string"},
+	}
+	for _, tt := range tests {
+		_, err := CompileExpr(tt.expr)
+		if err == nil {
+			t.Errorf("CompileExpr(%q) succeeded, want %q", tt.expr, tt.err)
+			continue
+		}
+		if err.Error() != tt.err {
+			t.Errorf("CompileExpr(%q) = %q, want %q", tt.expr, err, tt.err)
+		}
+	}
+}
+
+func TestExprMatch(t *testing.T) {
+	e, err := NewTestEvent(PortNew, "00:00:5e:00:53:01", net.ParseIP("192.168.86.10"), 22, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`type == "port.new"`, true},
+		{`type != "port.new"`, false},
+		{`type in ["host.new", "port.new"]`, true},
+		{`type in ["host.new", 22]`, false},
+		{`port.num == 22 && port.proto == "tcp"`, true},
+		{`port.num > 1024 || port.num <= 22`, true},
+		{`!(port.num >= 23)`, true},
+		{`host.ipv4 in cidr("192.168.86.0/24")`, true},
+		{`host.ipv4 in cidr("10.0.0.0/8")`, false},
+		{`host.ipv4 == "192.168.86.10"`, true},
+		{`host.ipv4 == ip("192.168.86.11")`, false},
+		{`host.ipv6 == ip("::1")`, false},
+		{`"port" in type`, true},
+		{`port.client == false`, true},
+	}
+	for _, tt := range tests {
+		x, err := CompileExpr(tt.expr)
+		if err != nil {
+			t.Errorf("CompileExpr(%q) failed: %v", tt.expr, err)
+			continue
+		}
+		if got := x.Match(e); got != tt.want {
+			t.Errorf("%q matched %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package watch
+
+import (
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// configDecoder loosely decodes the text of a config file of one format, into
+// data with the types that the toml decoder would give, along with the line of
+// each key by its dotted path.
+type configDecoder func(path string, text string) (map[string]interface{}, map[string]int, error)
+
+// configDecoders are the decoders of config files, by their extension.
+var configDecoders = map[string]configDecoder{
+	".toml": decodeTOML,
+	".yaml": decodeYAML,
+	".yml":  decodeYAML,
+	".json": decodeJSON,
+}
+
+// configDecoderOf returns the decoder of the config file at the given path,
+// from its extension.
+func configDecoderOf(path string) (configDecoder, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	decode, ok := configDecoders[ext]
+	if !ok {
+		return nil, errors.Errorf(
+			"unknown config format %q, expected one of .toml, .yaml, .yml or .json",
+			ext,
+		)
+	}
+	return decode, nil
+}
+
+func decodeTOML(path string, text string) (map[string]interface{}, map[string]int, error) {
+	var data map[string]interface{}
+	if _, err := toml.Decode(text, &data); err != nil {
+		return nil, nil, ConfigErrors{newParseError(path, err)}
+	}
+	return data, keyLines(text), nil
+}
+
+// unmarshalData decodes the given loosely decoded data, of any format, into the
+// given Config. It's done by way of JSON, whose decoder matches keys and
+// decodes text into values just as the toml decoder does.
+func unmarshalData(path string, data map[string]interface{}, conf *Config) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return ConfigErrors{{Path: path, Msg: err.Error()}}
+	}
+	if err := json.Unmarshal(b, conf); err != nil {
+		return ConfigErrors{{Path: path, Msg: err.Error()}}
+	}
+	return nil
+}
+
+// reYAMLError matches the errors returned by the yaml parser.
+var reYAMLError = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
+func decodeYAML(path string, text string) (map[string]interface{}, map[string]int, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(text), &doc); err != nil {
+		e := ConfigError{Path: path, Msg: err.Error()}
+		if m := reYAMLError.FindStringSubmatch(err.Error()); m != nil {
+			e.Line, _ = strconv.Atoi(m[1])
+			e.Msg = m[2]
+		}
+		return nil, nil, ConfigErrors{e}
+	}
+	lines := make(map[string]int)
+	if len(doc.Content) == 0 {
+		// An empty document.
+		return map[string]interface{}{}, lines, nil
+	}
+	data, err := yamlValue(nil, doc.Content[0], lines)
+	if err != nil {
+		return nil, nil, ConfigErrors{{Path: path, Msg: err.Error()}}
+	}
+	m, ok := data.(map[string]interface{})
+	if !ok {
+		return nil, nil, ConfigErrors{{
+			Path: path,
+			Line: doc.Content[0].Line,
+			Msg:  "expected a mapping at the top level",
+		}}
+	}
+	return m, lines, nil
+}
+
+// yamlValue returns the value of the given yaml node at the given key,
+// recording the line of it and any keys within it.
+func yamlValue(key []string, n *yaml.Node, lines map[string]int) (interface{}, error) {
+	switch n.Kind {
+	case yaml.AliasNode:
+		return yamlValue(key, n.Alias, lines)
+	case yaml.MappingNode:
+		m := make(map[string]interface{})
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, v := n.Content[i], n.Content[i+1]
+			if k.Kind != yaml.ScalarNode {
+				return nil, errors.Errorf("line %d: expected a string key", k.Line)
+			}
+			key := append(append([]string(nil), key...), k.Value)
+			lines[joinKey(key)] = k.Line
+			val, err := yamlValue(key, v, lines)
+			if err != nil {
+				return nil, err
+			}
+			m[k.Value] = val
+		}
+		return m, nil
+	case yaml.SequenceNode:
+		s := make([]interface{}, 0, len(n.Content))
+		for i, item := range n.Content {
+			key := append(append([]string(nil), key...), index(i))
+			lines[joinKey(key)] = item.Line
+			val, err := yamlValue(key, item, lines)
+			if err != nil {
+				return nil, err
+			}
+			s = append(s, val)
+		}
+		return s, nil
+	}
+	var val interface{}
+	if err := n.Decode(&val); err != nil {
+		return nil, err
+	}
+	switch v := val.(type) {
+	case int:
+		return int64(v), nil
+	case uint64:
+		return float64(v), nil
+	}
+	return val, nil
+}
+
+func decodeJSON(path string, text string) (map[string]interface{}, map[string]int, error) {
+	dec := json.NewDecoder(strings.NewReader(text))
+	dec.UseNumber()
+	var data interface{}
+	if err := dec.Decode(&data); err != nil {
+		e := ConfigError{Path: path, Msg: err.Error()}
+		if serr, ok := err.(*json.SyntaxError); ok {
+			e.Line = lineOf(text, serr.Offset)
+		}
+		return nil, nil, ConfigErrors{e}
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, nil, ConfigErrors{{
+			Path: path,
+			Line: lineOf(text, dec.InputOffset()),
+			Msg:  "unexpected data after the top level object",
+		}}
+	}
+	m, ok := jsonValue(data).(map[string]interface{})
+	if !ok {
+		return nil, nil, ConfigErrors{{Path: path, Msg: "expected an object at the top level"}}
+	}
+	lines := make(map[string]int)
+	// The text has already been decoded, so this can't fail.
+	_ = jsonLines(nil, text, json.NewDecoder(strings.NewReader(text)), lines)
+	return m, lines, nil
+}
+
+// jsonValue returns the given decoded JSON value, with its numbers as the
+// integers or floats that the toml decoder would give.
+func jsonValue(data interface{}) interface{} {
+	switch d := data.(type) {
+	case map[string]interface{}:
+		for k, v := range d {
+			d[k] = jsonValue(v)
+		}
+	case []interface{}:
+		for i, v := range d {
+			d[i] = jsonValue(v)
+		}
+	case json.Number:
+		if n, err := d.Int64(); err == nil {
+			return n
+		}
+		f, _ := d.Float64()
+		return f
+	}
+	return data
+}
+
+// jsonLines records the line of each key of the next value of the given
+// decoder, reading the given text, at the given key.
+func jsonLines(key []string, text string, dec *json.Decoder, lines map[string]int) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch tok {
+	case json.Delim('{'):
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			key := append(append([]string(nil), key...), tok.(string))
+			lines[joinKey(key)] = lineOf(text, dec.InputOffset())
+			if err := jsonLines(key, text, dec, lines); err != nil {
+				return err
+			}
+		}
+		_, err = dec.Token()
+	case json.Delim('['):
+		for i := 0; dec.More(); i++ {
+			key := append(append([]string(nil), key...), index(i))
+			lines[joinKey(key)] = lineOf(text, nextOffset(text, dec.InputOffset()))
+			if err := jsonLines(key, text, dec, lines); err != nil {
+				return err
+			}
+		}
+		_, err = dec.Token()
+	}
+	return err
+}
+
+// nextOffset returns the offset of the next value in the given JSON text, from
+// the given offset, skipping any whitespace and separators.
+func nextOffset(text string, offset int64) int64 {
+	for offset < int64(len(text)) && strings.IndexByte(" \t\r\n,:", text[offset]) >= 0 {
+		offset++
+	}
+	return offset
+}
+
+// lineOf returns the line of the given offset into the given text.
+func lineOf(text string, offset int64) int {
+	if offset > int64(len(text)) {
+		offset = int64(len(text))
+	}
+	return strings.Count(text[:offset], "\n") + 1
+}
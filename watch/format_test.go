@@ -0,0 +1,234 @@
+package watch
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// formatTests are the same config written in each format, with the line of
+// each of its keys.
+var formatTests = []struct {
+	path  string
+	text  string
+	lines map[string]int
+}{
+	{
+		path: "config.toml",
+		text: `# Triggers.
+[triggers]
+  [triggers.log]
+    onEvents = ["host.new", "port.new"]
+    doBuiltin = "log"
+    rateLimit = 5
+    [[triggers.log.enrich]]
+      builtin = "vendor"
+    [[triggers.log.enrich]]
+      shell = "owners"
+`,
+		lines: map[string]int{
+			"triggers":                       2,
+			"triggers.log":                   3,
+			"triggers.log.onEvents":          4,
+			"triggers.log.doBuiltin":         5,
+			"triggers.log.rateLimit":         6,
+			"triggers.log.enrich[0]":         7,
+			"triggers.log.enrich[0].builtin": 8,
+			"triggers.log.enrich[1]":         9,
+			"triggers.log.enrich[1].shell":   10,
+		},
+	},
+	{
+		path: "config.yaml",
+		text: `# Triggers.
+triggers:
+  log:
+    onEvents:
+      - host.new
+      - port.new
+    doBuiltin: log
+    rateLimit: 5
+    enrich:
+      - builtin: vendor
+      - shell: owners
+`,
+		lines: map[string]int{
+			"triggers":                       2,
+			"triggers.log":                   3,
+			"triggers.log.onEvents":          4,
+			"triggers.log.onEvents[0]":       5,
+			"triggers.log.onEvents[1]":       6,
+			"triggers.log.doBuiltin":         7,
+			"triggers.log.rateLimit":         8,
+			"triggers.log.enrich":            9,
+			"triggers.log.enrich[0]":         10,
+			"triggers.log.enrich[0].builtin": 10,
+			"triggers.log.enrich[1]":         11,
+			"triggers.log.enrich[1].shell":   11,
+		},
+	},
+	{
+		path: "config.json",
+		text: `{
+  "triggers": {
+    "log": {
+      "onEvents": [
+        "host.new",
+        "port.new"
+      ],
+      "doBuiltin": "log",
+      "rateLimit": 5,
+      "enrich": [
+        {"builtin": "vendor"},
+        {"shell": "owners"}
+      ]
+    }
+  }
+}
+`,
+		lines: map[string]int{
+			"triggers":                       2,
+			"triggers.log":                   3,
+			"triggers.log.onEvents":          4,
+			"triggers.log.onEvents[0]":       5,
+			"triggers.log.onEvents[1]":       6,
+			"triggers.log.doBuiltin":         8,
+			"triggers.log.rateLimit":         9,
+			"triggers.log.enrich":            10,
+			"triggers.log.enrich[0]":         11,
+			"triggers.log.enrich[0].builtin": 11,
+			"triggers.log.enrich[1]":         12,
+			"triggers.log.enrich[1].shell":   12,
+		},
+	},
+}
+
+func TestDecodeConfigLines(t *testing.T) {
+	var want map[string]interface{}
+	for _, tt := range formatTests {
+		decode, err := configDecoderOf(tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, lines, err := decode(tt.path, tt.text)
+		if err != nil {
+			t.Errorf("%s: failed to decode: %v", tt.path, err)
+			continue
+		}
+		for key, line := range tt.lines {
+			if lines[key] != line {
+				t.Errorf("%s: %s is on line %d, want %d", tt.path, key, lines[key], line)
+			}
+		}
+		// Each format should decode to the same data as the toml
+		// decoder gives.
+		if want == nil {
+			want = data
+		} else if !reflect.DeepEqual(stripTables(data), stripTables(want)) {
+			t.Errorf("%s: decoded %#v, want %#v", tt.path, data, want)
+		}
+	}
+}
+
+// stripTables returns the given decoded data with arrays of tables, which
+// the toml decoder gives as []map[string]interface{}, as []interface{} as
+// the other decoders give them.
+func stripTables(data interface{}) interface{} {
+	switch d := data.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(d))
+		for k, v := range d {
+			m[k] = stripTables(v)
+		}
+		return m
+	case []map[string]interface{}:
+		s := make([]interface{}, len(d))
+		for i, v := range d {
+			s[i] = stripTables(v)
+		}
+		return s
+	case []interface{}:
+		s := make([]interface{}, len(d))
+		for i, v := range d {
+			s[i] = stripTables(v)
+		}
+		return s
+	}
+	return data
+}
+
+func TestDecodeConfigErrorLines(t *testing.T) {
+	tests := []struct {
+		path string
+		text string
+		line int
+	}{
+		{"config.toml", "[triggers]\n  [triggers.log\n", 2},
+		{"config.yaml", "triggers:\n  log:\n\tdoBuiltin: log\n", 3},
+		{"config.json", "{\n  \"triggers\": {\n    \"log\": }\n}\n", 3},
+		{"config.json", "{}\n{}\n", 2},
+	}
+	for _, tt := range tests {
+		decode, err := configDecoderOf(tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, err = decode(tt.path, tt.text)
+		errs, ok := err.(ConfigErrors)
+		if !ok || len(errs) == 0 {
+			t.Errorf("%s: decoding %q gave %v, want ConfigErrors", tt.path, tt.text, err)
+			continue
+		}
+		if errs[0].Line != tt.line {
+			t.Errorf("%s: decoding %q failed on line %d, want %d: %v",
+				tt.path, tt.text, errs[0].Line, tt.line, errs[0])
+		}
+	}
+}
+
+func TestLoadConfigErrorLines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netwatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{
+			"config.toml",
+			"[triggers.log]\ndoBuiltin = \"log\"\nonEvent = [\"host.new\"]\n",
+			"config.toml:3: triggers.log.onEvent: unknown key, did you mean \"onEvents\"?",
+		},
+		{
+			"config.yaml",
+			"triggers:\n  log:\n    doBuiltin: log\n    onEvent: [host.new]\n",
+			"config.yaml:4: triggers.log.onEvent: unknown key, did you mean \"onEvents\"?",
+		},
+		{
+			"config.json",
+			"{\n  \"triggers\": {\n    \"log\": {\n      \"doBuiltin\": \"log\",\n" +
+				"      \"onEvent\": [\"host.new\"]\n    }\n  }\n}\n",
+			"config.json:5: triggers.log.onEvent: unknown key, did you mean \"onEvents\"?",
+		},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.name)
+		if err := ioutil.WriteFile(path, []byte(tt.text), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := LoadConfig(path)
+		if err == nil {
+			t.Errorf("%s: loaded, want %q", tt.name, tt.want)
+			continue
+		}
+		if got := err.Error(); got != filepath.Join(dir, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, filepath.Join(dir, tt.want))
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package watch
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/google/gopacket/reassembly"
+)
+
+// maxHTTPBuffer is the most bytes buffered for one direction of a stream,
+// while waiting for a complete HTTP header or chunked body. Streams that
+// exceed it are given up on.
+const maxHTTPBuffer = 64 * 1024
+
+// maxHTTPPending is the most requests on a stream that may wait for their
+// responses. Beyond that the oldest are emitted without a status, such as when
+// only one direction of a stream is captured.
+const maxHTTPPending = 32
+
+// HTTPTransaction describes a single plaintext HTTP request, and the status
+// of its response if one was seen.
+type HTTPTransaction struct {
+	Method    string
+	Host      string
+	Path      string
+	UserAgent string
+	// Status is the response status code, or zero if none was seen before
+	// the connection ended, or before too many later requests were made.
+	Status int
+}
+
+// Resource returns the requested domain and path, e.g. example.com/foobar.
+func (tx HTTPTransaction) Resource() string {
+	return tx.Host + tx.Path
+}
+
+// ResourceNoQuery returns the requested domain and path without any query
+// string, e.g. example.com/search for example.com/search?q=foo.
+func (tx HTTPTransaction) ResourceNoQuery() string {
+	path := tx.Path
+	if i := strings.IndexAny(path, "?#"); i >= 0 {
+		path = path[:i]
+	}
+	return tx.Host + path
+}
+
+// maxHostCounts is how many distinct resources, and User-Agents, are counted
+// for each host. Beyond that the least counted are forgotten, so that hosts
+// such as crawlers don't grow them without bound.
+const maxHostCounts = 256
+
+// countBounded increments the count of the given key, first forgetting the
+// least counted key if there are already max others.
+func countBounded(counts map[string]int, key string, max int) {
+	if _, ok := counts[key]; !ok && len(counts) >= max {
+		var least string
+		leastN := -1
+		for k, n := range counts {
+			if leastN < 0 || n < leastN || (n == leastN && k < least) {
+				least, leastN = k, n
+			}
+		}
+		delete(counts, least)
+	}
+	counts[key]++
+}
+
+type httpKind int
+
+const (
+	httpUnknown httpKind = iota
+	httpRequests
+	httpResponses
+	httpNone
+)
+
+// httpHalf incrementally parses HTTP messages from one direction of a TCP
+// stream.
+type httpHalf struct {
+	kind  httpKind
+	buf   []byte
+	skip  int64
+	toEOF bool
+}
+
+// httpStream pairs requests with responses on a single TCP connection,
+// supporting both keep-alive and pipelining.
+type httpStream struct {
+	halves  [2]httpHalf
+	pending []pendingHTTP
+	emit    func(mac MAC, tx HTTPTransaction)
+}
+
+type pendingHTTP struct {
+	mac  MAC
+	tx   HTTPTransaction
+	head bool
+}
+
+func newHTTPParser(
+	conn StreamConn,
+	report func(mac MAC, result interface{}),
+) StreamParser {
+	return &httpStream{
+		emit: func(mac MAC, tx HTTPTransaction) {
+			report(mac, tx)
+		},
+	}
+}
+
+// Feed satisfies the StreamParser interface. Either direction may hold the
+// requests, since we can't trust which side sent the first packet we saw.
+func (s *httpStream) Feed(c StreamChunk) bool {
+	h := &s.halves[0]
+	if c.Dir == reassembly.TCPDirServerToClient {
+		h = &s.halves[1]
+	}
+	s.feed(h, c.Src, c.Data, c.Gap)
+	return s.halves[0].kind != httpNone || s.halves[1].kind != httpNone
+}
+
+func (s *httpStream) feed(h *httpHalf, mac MAC, data []byte, gap bool) {
+	if gap {
+		// We can't know where the next message starts, so start
+		// over and hope that it's on a message boundary.
+		h.buf = nil
+		h.skip = 0
+		h.toEOF = false
+		if h.kind != httpNone {
+			h.kind = httpUnknown
+		}
+	}
+	if h.kind == httpNone || h.toEOF {
+		return
+	}
+	if h.skip > 0 {
+		n := int64(len(data))
+		if n > h.skip {
+			n = h.skip
+		}
+		data = data[n:]
+		h.skip -= n
+	}
+	h.buf = append(h.buf, data...)
+	if h.kind == httpUnknown && len(h.buf) > 0 {
+		h.kind = classifyHTTP(h.buf)
+	}
+	switch h.kind {
+	case httpRequests:
+		s.parseRequests(h, mac)
+	case httpResponses:
+		s.parseResponses(h)
+	}
+	if len(h.buf) > maxHTTPBuffer {
+		h.kind = httpNone
+	}
+	if h.kind == httpNone {
+		h.buf = nil
+	}
+}
+
+// Close satisfies the StreamParser interface, and emits any requests that
+// never saw a response.
+func (s *httpStream) Close() {
+	for _, p := range s.pending {
+		s.emit(p.mac, p.tx)
+	}
+	s.pending = nil
+}
+
+func (s *httpStream) parseRequests(h *httpHalf, mac MAC) {
+	for h.skip == 0 && hasHeaderEnd(h.buf) {
+		r := bytes.NewReader(h.buf)
+		br := bufio.NewReader(r)
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			if !isShortRead(err) {
+				h.kind = httpNone
+			}
+			return
+		}
+		if isChunked(req.TransferEncoding) {
+			if _, err := io.Copy(ioutil.Discard, req.Body); err != nil {
+				return
+			}
+		} else if req.ContentLength > 0 {
+			h.skip = req.ContentLength
+		}
+		h.consume(len(h.buf) - r.Len() - br.Buffered())
+		if len(s.pending) >= maxHTTPPending {
+			p := s.pending[0]
+			s.pending = s.pending[1:]
+			s.emit(p.mac, p.tx)
+		}
+		s.pending = append(s.pending, pendingHTTP{
+			mac: mac,
+			tx: HTTPTransaction{
+				Method:    req.Method,
+				Host:      req.Host,
+				Path:      req.URL.Path,
+				UserAgent: req.UserAgent(),
+			},
+			head: req.Method == http.MethodHead,
+		})
+	}
+}
+
+func (s *httpStream) parseResponses(h *httpHalf) {
+	for h.skip == 0 && !h.toEOF && hasHeaderEnd(h.buf) {
+		r := bytes.NewReader(h.buf)
+		br := bufio.NewReader(r)
+		var req *http.Request
+		if len(s.pending) > 0 && s.pending[0].head {
+			req = &http.Request{Method: http.MethodHead}
+		}
+		resp, err := http.ReadResponse(br, req)
+		if err != nil {
+			if !isShortRead(err) {
+				h.kind = httpNone
+			}
+			return
+		}
+		switch {
+		case req != nil, resp.StatusCode == http.StatusNoContent,
+			resp.StatusCode == http.StatusNotModified:
+			// No body.
+		case resp.StatusCode == http.StatusSwitchingProtocols:
+			h.toEOF = true
+		case resp.StatusCode < 200:
+			// Informational, e.g. 100 Continue, precedes the
+			// final response for the same request.
+			h.consume(len(h.buf) - r.Len() - br.Buffered())
+			continue
+		case isChunked(resp.TransferEncoding):
+			if _, err := io.Copy(ioutil.Discard, resp.Body); err != nil {
+				return
+			}
+		case resp.ContentLength >= 0:
+			h.skip = resp.ContentLength
+		default:
+			// Delimited by the connection closing.
+			h.toEOF = true
+		}
+		h.consume(len(h.buf) - r.Len() - br.Buffered())
+		if len(s.pending) == 0 {
+			continue
+		}
+		p := s.pending[0]
+		s.pending = s.pending[1:]
+		p.tx.Status = resp.StatusCode
+		s.emit(p.mac, p.tx)
+	}
+}
+
+// consume drops the first n bytes from the buffer, and any of the body that
+// is to be skipped after them.
+func (h *httpHalf) consume(n int) {
+	h.buf = h.buf[n:]
+	if h.skip > 0 {
+		m := int64(len(h.buf))
+		if m > h.skip {
+			m = h.skip
+		}
+		h.buf = h.buf[m:]
+		h.skip -= m
+	}
+	if h.toEOF {
+		h.buf = nil
+	}
+	if len(h.buf) == 0 {
+		h.buf = nil
+	}
+}
+
+var httpPrefixes = map[string]httpKind{
+	"HTTP/":    httpResponses,
+	"GET ":     httpRequests,
+	"HEAD ":    httpRequests,
+	"POST ":    httpRequests,
+	"PUT ":     httpRequests,
+	"DELETE ":  httpRequests,
+	"CONNECT ": httpRequests,
+	"OPTIONS ": httpRequests,
+	"TRACE ":   httpRequests,
+	"PATCH ":   httpRequests,
+}
+
+// classifyHTTP decides whether the start of a stream looks like HTTP requests
+// or responses, returning httpUnknown if there isn't enough data yet.
+func classifyHTTP(b []byte) httpKind {
+	kind := httpNone
+	for prefix, k := range httpPrefixes {
+		n := len(prefix)
+		if len(b) < n {
+			n = len(b)
+		}
+		if string(b[:n]) != prefix[:n] {
+			continue
+		}
+		if n == len(prefix) {
+			return k
+		}
+		kind = httpUnknown
+	}
+	return kind
+}
+
+// hasHeaderEnd returns whether the given bytes hold a complete message
+// header, since net/http would otherwise parse a partial line as if it were
+// whole.
+func hasHeaderEnd(b []byte) bool {
+	return bytes.Contains(b, []byte("\r\n\r\n")) ||
+		bytes.Contains(b, []byte("\n\n"))
+}
+
+func isShortRead(err error) bool {
+	return err == io.EOF || err == io.ErrUnexpectedEOF
+}
+
+func isChunked(te []string) bool {
+	return len(te) > 0 && te[0] == "chunked"
+}
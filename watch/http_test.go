@@ -0,0 +1,211 @@
+package watch
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/gopacket/reassembly"
+)
+
+const (
+	toServer = reassembly.TCPDirClientToServer
+	toClient = reassembly.TCPDirServerToClient
+)
+
+type httpChunk struct {
+	dir  reassembly.TCPFlowDirection
+	data string
+	gap  bool
+}
+
+func httpGet(path string) string {
+	return "GET " + path + " HTTP/1.1\r\nHost: example.com\r\nUser-Agent: test\r\n\r\n"
+}
+
+func httpOK(body string) string {
+	return fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func httpTx(method, path string, status int) HTTPTransaction {
+	return HTTPTransaction{
+		Method:    method,
+		Host:      "example.com",
+		Path:      path,
+		UserAgent: "test",
+		Status:    status,
+	}
+}
+
+func TestHTTPStream(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []httpChunk
+		want   []HTTPTransaction
+	}{
+		{
+			name: "keep-alive",
+			chunks: []httpChunk{
+				{toServer, httpGet("/a"), false},
+				{toClient, httpOK("hello"), false},
+				{toServer, httpGet("/b?q=1"), false},
+				{toClient, "HTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n", false},
+			},
+			want: []HTTPTransaction{httpTx("GET", "/a", 200), httpTx("GET", "/b", 404)},
+		},
+		{
+			name: "pipelined",
+			chunks: []httpChunk{
+				{toServer, httpGet("/a") + httpGet("/b") + httpGet("/c"), false},
+				{toClient, httpOK("one") + httpOK("two"), false},
+				{toClient, "HTTP/1.1 304 Not Modified\r\n\r\n", false},
+			},
+			want: []HTTPTransaction{
+				httpTx("GET", "/a", 200),
+				httpTx("GET", "/b", 200),
+				httpTx("GET", "/c", 304),
+			},
+		},
+		{
+			name: "split across chunks",
+			chunks: []httpChunk{
+				{toServer, "GET /a HTTP/1.1\r\nHost: exam", false},
+				{toServer, "ple.com\r\nUser-Agent: test\r\n\r\n", false},
+				{toClient, "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nhello", false},
+				{toClient, "HTTP/", false},
+			},
+			want: []HTTPTransaction{httpTx("GET", "/a", 200)},
+		},
+		{
+			name: "request bodies",
+			chunks: []httpChunk{
+				{toServer, "POST /form HTTP/1.1\r\nHost: example.com\r\nUser-Agent: test\r\n" +
+					"Content-Length: 11\r\n\r\nGET /x HTTP", false},
+				{toServer, "PUT /up HTTP/1.1\r\nHost: example.com\r\nUser-Agent: test\r\n" +
+					"Transfer-Encoding: chunked\r\n\r\n4\r\nGET \r\n0\r\n\r\n" + httpGet("/b"), false},
+				{toClient, httpOK("") + httpOK("") + httpOK(""), false},
+			},
+			want: []HTTPTransaction{
+				httpTx("POST", "/form", 200),
+				httpTx("PUT", "/up", 200),
+				httpTx("GET", "/b", 200),
+			},
+		},
+		{
+			name: "chunked response",
+			chunks: []httpChunk{
+				{toServer, httpGet("/a") + httpGet("/b"), false},
+				{toClient, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n" +
+					"13\r\nHTTP/1.1 500 Oops\r\n\r\n", false},
+				{toClient, "0\r\n\r\n" + httpOK("two"), false},
+			},
+			want: []HTTPTransaction{httpTx("GET", "/a", 200), httpTx("GET", "/b", 200)},
+		},
+		{
+			name: "HEAD has no response body",
+			chunks: []httpChunk{
+				{toServer, "HEAD /a HTTP/1.1\r\nHost: example.com\r\nUser-Agent: test\r\n\r\n" +
+					httpGet("/b"), false},
+				{toClient, "HTTP/1.1 200 OK\r\nContent-Length: 1000\r\n\r\n" + httpOK("two"), false},
+			},
+			want: []HTTPTransaction{httpTx("HEAD", "/a", 200), httpTx("GET", "/b", 200)},
+		},
+		{
+			name: "informational responses",
+			chunks: []httpChunk{
+				{toServer, httpGet("/a"), false},
+				{toClient, "HTTP/1.1 100 Continue\r\n\r\n", false},
+				{toClient, "HTTP/1.1 103 Early Hints\r\nLink: </style.css>\r\n\r\n" + httpOK(""), false},
+			},
+			want: []HTTPTransaction{httpTx("GET", "/a", 200)},
+		},
+		{
+			name: "switching protocols",
+			chunks: []httpChunk{
+				{toServer, httpGet("/ws"), false},
+				{toClient, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\n\r\n" +
+					"HTTP/1.1 200 OK\r\n\r\n", false},
+				{toClient, httpOK("not http"), false},
+			},
+			want: []HTTPTransaction{httpTx("GET", "/ws", 101)},
+		},
+		{
+			name: "responses first",
+			chunks: []httpChunk{
+				{toClient, httpGet("/a"), false},
+				{toServer, httpOK("reversed"), false},
+			},
+			want: []HTTPTransaction{httpTx("GET", "/a", 200)},
+		},
+		{
+			name: "unanswered on close",
+			chunks: []httpChunk{
+				{toServer, httpGet("/a") + httpGet("/b"), false},
+				{toClient, httpOK(""), false},
+			},
+			want: []HTTPTransaction{httpTx("GET", "/a", 200), httpTx("GET", "/b", 0)},
+		},
+		{
+			name: "gap",
+			chunks: []httpChunk{
+				{toServer, "GET /a HTTP/1.1\r\nHost: exa", false},
+				{toServer, httpGet("/b"), true},
+			},
+			want: []HTTPTransaction{httpTx("GET", "/b", 0)},
+		},
+		{
+			name: "not http",
+			chunks: []httpChunk{
+				{toServer, "SSH-2.0-OpenSSH_8.2\r\n\r\n", false},
+				{toClient, "SSH-2.0-OpenSSH_8.2\r\n\r\n", false},
+			},
+		},
+	}
+	for _, tt := range tests {
+		var got []HTTPTransaction
+		s := &httpStream{emit: func(mac MAC, tx HTTPTransaction) {
+			got = append(got, tx)
+		}}
+		for _, c := range tt.chunks {
+			s.Feed(StreamChunk{Dir: c.dir, Data: []byte(c.data), Gap: c.gap})
+		}
+		s.Close()
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHTTPStreamNotHTTP(t *testing.T) {
+	s := &httpStream{emit: func(MAC, HTTPTransaction) {}}
+	if !s.Feed(StreamChunk{Dir: toServer, Data: []byte("GE")}) {
+		t.Errorf("gave up on a partial request method")
+	}
+	if !s.Feed(StreamChunk{Dir: toServer, Data: []byte("X / HTTP/1.1\r\n")}) {
+		t.Errorf("gave up before seeing the other direction")
+	}
+	if s.Feed(StreamChunk{Dir: toClient, Data: []byte("SSH-2.0-OpenSSH_8.2\r\n")}) {
+		t.Errorf("still interested after neither direction was HTTP")
+	}
+}
+
+func TestHTTPStreamPendingCap(t *testing.T) {
+	var got []HTTPTransaction
+	s := &httpStream{emit: func(mac MAC, tx HTTPTransaction) {
+		got = append(got, tx)
+	}}
+	n := maxHTTPPending + 2
+	s.Feed(StreamChunk{Dir: toServer, Data: []byte(strings.Repeat(httpGet("/a"), n))})
+	if len(got) != 2 {
+		t.Fatalf("emitted %d requests before any response, want 2", len(got))
+	}
+	s.Feed(StreamChunk{Dir: toClient, Data: []byte(httpOK(""))})
+	if len(got) != 3 || got[2].Status != 200 {
+		t.Fatalf("got %+v after a response, want a third with status 200", got)
+	}
+	s.Close()
+	if len(got) != n {
+		t.Errorf("emitted %d requests, want %d", len(got), n)
+	}
+}
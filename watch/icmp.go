@@ -0,0 +1,323 @@
+package watch
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+// Default thresholds of ICMPConfig.
+const (
+	defaultICMPWindow         = 10 * time.Second
+	defaultICMPPingTargets    = 16
+	defaultICMPTracerouteHops = 4
+	defaultICMPTTL            = 10 * time.Second
+)
+
+// maxTracerouteTTL is the highest TTL counted as a traceroute probe, above
+// which packets are assumed to be sent with an operating system's default.
+const maxTracerouteTTL = 32
+
+// The range of UDP ports that traceroute sends probes to by default, starting
+// at 33434 and incrementing for each probe.
+const (
+	tracerouteUDPPortMin = 33434
+	tracerouteUDPPortMax = 33534
+)
+
+// ICMPConfig holds the thresholds for detecting ping sweeps and traceroutes.
+// A host is ping sweeping once it sends echo requests to at least PingTargets
+// distinct targets within Window, and tracing a route once it sends packets
+// with at least TracerouteHops distinct low TTLs to the same target within
+// Window. Either stops once it hasn't done so enough for TTL. Zero values use
+// defaults.
+type ICMPConfig struct {
+	Window         Duration
+	PingTargets    int
+	TracerouteHops int
+	TTL            Duration
+}
+
+func (c ICMPConfig) withDefaults() ICMPConfig {
+	if c.Window.Duration <= 0 {
+		c.Window.Duration = defaultICMPWindow
+	}
+	if c.PingTargets <= 0 {
+		c.PingTargets = defaultICMPPingTargets
+	}
+	if c.TracerouteHops <= 0 {
+		c.TracerouteHops = defaultICMPTracerouteHops
+	}
+	if c.TTL.Duration <= 0 {
+		c.TTL.Duration = defaultICMPTTL
+	}
+	return c
+}
+
+// Types of ICMPMessage.
+const (
+	ICMPEchoRequest  = "echo-request"
+	ICMPEchoReply    = "echo-reply"
+	ICMPTimeExceeded = "time-exceeded"
+	ICMPUnreachable  = "unreachable"
+)
+
+// ICMPMessage holds an ICMPv4 or ICMPv6 echo, time exceeded or destination
+// unreachable message.
+type ICMPMessage struct {
+	Type string
+	Code uint8
+	// Original is the destination of the packet that caused an error
+	// message, if it was quoted.
+	Original net.IP
+}
+
+func newICMPv4Message(icmp *layers.ICMPv4) (*ICMPMessage, bool) {
+	m := ICMPMessage{Code: icmp.TypeCode.Code()}
+	switch icmp.TypeCode.Type() {
+	case layers.ICMPv4TypeEchoRequest:
+		m.Type = ICMPEchoRequest
+	case layers.ICMPv4TypeEchoReply:
+		m.Type = ICMPEchoReply
+	case layers.ICMPv4TypeTimeExceeded:
+		m.Type = ICMPTimeExceeded
+	case layers.ICMPv4TypeDestinationUnreachable:
+		m.Type = ICMPUnreachable
+	default:
+		return nil, false
+	}
+	// The payload of an error starts with the original IPv4 header.
+	if m.Type == ICMPTimeExceeded || m.Type == ICMPUnreachable {
+		if b := icmp.Payload; len(b) >= 20 {
+			m.Original = copyIP(b[16:20])
+		}
+	}
+	return &m, true
+}
+
+func newICMPv6Message(icmp *layers.ICMPv6) (*ICMPMessage, bool) {
+	m := ICMPMessage{Code: icmp.TypeCode.Code()}
+	switch icmp.TypeCode.Type() {
+	case layers.ICMPv6TypeEchoRequest:
+		m.Type = ICMPEchoRequest
+	case layers.ICMPv6TypeEchoReply:
+		m.Type = ICMPEchoReply
+	case layers.ICMPv6TypeTimeExceeded:
+		m.Type = ICMPTimeExceeded
+	case layers.ICMPv6TypeDestinationUnreachable:
+		m.Type = ICMPUnreachable
+	default:
+		return nil, false
+	}
+	// The payload of an error is 4 unused bytes, followed by the original
+	// IPv6 header.
+	if m.Type == ICMPTimeExceeded || m.Type == ICMPUnreachable {
+		if b := icmp.Payload; len(b) >= 44 {
+			m.Original = copyIP(b[28:44])
+		}
+	}
+	return &m, true
+}
+
+// Traceroute describes a route being traced by a host.
+type Traceroute struct {
+	Target net.IP
+	// Hops are the addresses that have answered since the traceroute was
+	// detected, with time exceeded or destination unreachable messages,
+	// in order.
+	Hops []net.IP
+}
+
+func (t Traceroute) String() string {
+	return fmt.Sprintf("traceroute to %s (%d hops)", t.Target, len(t.Hops))
+}
+
+// ipTTL returns the TTL, or hop limit, of the given packet.
+func ipTTL(vp ViewPair) (uint8, bool) {
+	switch {
+	case vp.IPv4 != nil:
+		return vp.IPv4.TTL, true
+	case vp.IPv6 != nil:
+		return vp.IPv6.HopLimit, true
+	}
+	return 0, false
+}
+
+// updateICMP detects ping sweeps and traceroutes by the given host, which sent
+// the given packet, and records answers to traceroutes.
+func (w *Watcher) updateICMP(
+	hosts map[MAC]*Host,
+	h *Host,
+	vp ViewPair,
+	now time.Time,
+) {
+	dst := vp.Dst.IPv4
+	if dst == nil {
+		dst = vp.Dst.IPv6
+	}
+	if dst == nil || dst.IsMulticast() || dst.Equal(net.IPv4bcast) {
+		return
+	}
+	c := w.conf.ICMP.withDefaults()
+	if m := vp.Src.ICMP; m != nil {
+		switch m.Type {
+		case ICMPEchoRequest:
+			w.updatePingSweep(h, dst, c, now)
+		case ICMPTimeExceeded, ICMPUnreachable:
+			src := vp.Src.IPv4
+			if src == nil {
+				src = vp.Src.IPv6
+			}
+			w.recordHop(hosts, dst, src, m)
+		}
+	}
+	if ttl, ok := ipTTL(vp); ok && ttl <= maxTracerouteTTL && isTraceProbe(vp) {
+		w.updateTraceroute(h, dst, ttl, c, now)
+	}
+}
+
+// isTraceProbe returns whether the given packet is of the kind sent by common
+// traceroute implementations, i.e. an echo request, a UDP packet to the
+// traditional traceroute ports, or a TCP SYN. This avoids mistaking forwarded
+// packets, whose TTLs have been decremented along the way, for traceroutes.
+func isTraceProbe(vp ViewPair) bool {
+	switch {
+	case vp.Src.ICMP != nil:
+		return vp.Src.ICMP.Type == ICMPEchoRequest
+	case vp.UDP != nil:
+		return vp.UDP.DstPort >= tracerouteUDPPortMin &&
+			vp.UDP.DstPort <= tracerouteUDPPortMax
+	case vp.TCP != nil:
+		return vp.TCP.SYN && !vp.TCP.ACK
+	}
+	return false
+}
+
+// updatePingSweep counts an echo request sent by the given host to the given
+// target, and raises host.ping-sweep.start once the host has pinged enough
+// distinct targets. Once the host stops, host.ping-sweep.stop is raised.
+func (w *Watcher) updatePingSweep(
+	h *Host,
+	dst net.IP,
+	c ICMPConfig,
+	now time.Time,
+) {
+	if h.pings == nil {
+		h.pings = newProbeWindow(c.Window.Duration)
+	}
+	h.pings.Add(probe{at: now, target: dst.String(), port: ICMPEchoRequest})
+	targets := h.pings.Targets(ICMPEchoRequest)
+	if targets < c.PingTargets {
+		return
+	}
+	w.mu.Lock()
+	if h.ActivityPingSweep == nil {
+		h.ActivityPingSweep = newActivity(w.clock, c.TTL.Duration, func(a *Activity) {
+			w.mu.Lock()
+			up := a.Up()
+			w.mu.Unlock()
+			w.emit(Event{
+				Type: HostPingSweepStop,
+				Body: EventHostPingSweepStop{
+					Host: h,
+					Up:   up,
+				},
+			})
+		})
+	}
+	started := !h.ActivityPingSweep.Touch(now)
+	w.mu.Unlock()
+	if started {
+		w.emit(Event{
+			Type: HostPingSweepStart,
+			Body: EventHostPingSweepStart{h, targets},
+		})
+	}
+}
+
+// updateTraceroute counts a packet sent by the given host with the given low
+// TTL to the given target, and raises host.traceroute.start once the host has
+// sent enough distinct TTLs to it. Once the host stops, host.traceroute.stop
+// is raised.
+func (w *Watcher) updateTraceroute(
+	h *Host,
+	dst net.IP,
+	ttl uint8,
+	c ICMPConfig,
+	now time.Time,
+) {
+	if h.ttls == nil {
+		h.ttls = newProbeWindow(c.Window.Duration)
+	}
+	target := dst.String()
+	h.ttls.Add(probe{at: now, target: target, port: fmt.Sprint(ttl)})
+	if h.ttls.Ports(target) < c.TracerouteHops {
+		return
+	}
+	// The route is read by the expiry callback, outside of this goroutine.
+	w.mu.Lock()
+	if h.ActivityTraceroute == nil {
+		h.ActivityTraceroute = newActivity(w.clock, c.TTL.Duration, func(a *Activity) {
+			w.mu.Lock()
+			route := *h.Traceroute
+			up := a.Up()
+			w.mu.Unlock()
+			w.emit(Event{
+				Type: HostTracerouteStop,
+				Body: EventHostTracerouteStop{
+					Host:  h,
+					Route: route,
+					Up:    up,
+				},
+			})
+		})
+	}
+	if !h.ActivityTraceroute.IsActive || h.Traceroute == nil ||
+		!h.Traceroute.Target.Equal(dst) {
+		h.Traceroute = &Traceroute{Target: copyIP(dst)}
+	}
+	route := *h.Traceroute
+	started := !h.ActivityTraceroute.Touch(now)
+	w.mu.Unlock()
+	if started {
+		w.emit(Event{
+			Type: HostTracerouteStart,
+			Body: EventHostTracerouteStart{h, route},
+		})
+	}
+}
+
+// recordHop records the sender of the given ICMP error as a hop of the route
+// being traced by the host with the given address, if any.
+func (w *Watcher) recordHop(
+	hosts map[MAC]*Host,
+	tracer net.IP,
+	hop net.IP,
+	m *ICMPMessage,
+) {
+	if hop == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, h := range hosts {
+		if h.Traceroute == nil || !h.ActivityTraceroute.IsActive {
+			continue
+		}
+		if !h.IPv4.Equal(tracer) && !h.IPv6.Equal(tracer) {
+			continue
+		}
+		t := h.Traceroute
+		if m.Original != nil && !m.Original.Equal(t.Target) {
+			continue
+		}
+		if containsIP(t.Hops, hop) {
+			return
+		}
+		w.log.Debugf("%s hop %d is %s (%s)", t, len(t.Hops)+1, hop, m.Type)
+		t.Hops = append(t.Hops, copyIP(hop))
+		return
+	}
+}
@@ -0,0 +1,303 @@
+package watch
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// triggerExclusive are the groups of trigger keys of which only one may be
+// set. Setting one of a group over a trigger replaces any others of the group
+// that were set beneath it, so that a trigger can be changed from, say,
+// doBuiltin to doShell.
+var triggerExclusive = [][]string{
+	{"onAny", "onEvents", "onEventsExcept", "onShell", "onExpr"},
+	{"doBuiltin", "doShell"},
+}
+
+// configLayer is the loosely decoded data of a config file, or of a profile,
+// with the position that each of its keys was set at.
+type configLayer struct {
+	data map[string]interface{}
+	pos  map[string]configPos
+}
+
+func newConfigLayer() configLayer {
+	return configLayer{
+		data: make(map[string]interface{}),
+		pos:  make(map[string]configPos),
+	}
+}
+
+// merge sets the given layer over this one. Tables are merged key by key,
+// such as triggers by name, and anything else is replaced.
+func (l configLayer) merge(o configLayer) {
+	mergeData(nil, l.data, o.data)
+	for k, p := range o.pos {
+		l.pos[k] = p
+	}
+}
+
+func mergeData(key []string, dst, src map[string]interface{}) {
+	if len(key) == 2 && strings.EqualFold(key[0], "triggers") {
+		for _, group := range triggerExclusive {
+			if !hasKeyFold(src, group) {
+				continue
+			}
+			for k := range dst {
+				if containsFold(group, k) {
+					delete(dst, k)
+				}
+			}
+		}
+	}
+	for k, v := range src {
+		s, ok := v.(map[string]interface{})
+		d, dok := dst[k].(map[string]interface{})
+		if ok && dok {
+			mergeData(append(append([]string(nil), key...), k), d, s)
+			continue
+		}
+		dst[k] = copyData(v)
+	}
+}
+
+// hasKeyFold returns whether the given data has any of the given keys, ignoring
+// case as the decoders do.
+func hasKeyFold(data map[string]interface{}, keys []string) bool {
+	for k := range data {
+		if containsFold(keys, k) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(keys []string, key string) bool {
+	for _, k := range keys {
+		if strings.EqualFold(k, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// copyData returns a copy of the given data, so that merging over it doesn't
+// change the layer it came from.
+func copyData(data interface{}) interface{} {
+	switch d := data.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(d))
+		for k, v := range d {
+			m[k] = copyData(v)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(d))
+		for i, v := range d {
+			s[i] = copyData(v)
+		}
+		return s
+	case []map[string]interface{}:
+		s := make([]map[string]interface{}, len(d))
+		for i, v := range d {
+			s[i] = copyData(v).(map[string]interface{})
+		}
+		return s
+	}
+	return data
+}
+
+// take removes the top-level key of the given name from this layer, returning
+// its value, and the key as it was written, if any.
+func (l configLayer) take(name string) (interface{}, string) {
+	for k, v := range l.data {
+		if strings.EqualFold(k, name) {
+			delete(l.data, k)
+			return v, k
+		}
+	}
+	return nil, ""
+}
+
+// sub returns the layer of the table at the given key of this one.
+func (l configLayer) sub(key string, data map[string]interface{}) configLayer {
+	sub := configLayer{data: data, pos: make(map[string]configPos)}
+	for k, p := range l.pos {
+		if strings.HasPrefix(k, key+".") {
+			sub.pos[strings.TrimPrefix(k, key+".")] = p
+		}
+	}
+	return sub
+}
+
+// configLoader reads config files, along with those that they include.
+type configLoader struct {
+	errs    ConfigErrors
+	sources []string
+	// stack are the files being read, to find include cycles.
+	stack []string
+}
+
+// load returns the layer of the config file at the given path, with those it
+// includes merged beneath it, and the layers of the profiles that it and they
+// define. Problems with the files are collected, where only failing to read
+// the given file itself is returned.
+func (ld *configLoader) load(path string) (configLayer, map[string]configLayer, error) {
+	layer, defined := newConfigLayer(), make(map[string]configLayer)
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return layer, defined, err
+	}
+	for i, p := range ld.stack {
+		if p == abs {
+			cycle := append(append([]string(nil), ld.stack[i:]...), abs)
+			return layer, defined, errors.Errorf("include cycle %s", strings.Join(cycle, " -> "))
+		}
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return layer, defined, err
+	}
+	ld.sources = append(ld.sources, abs)
+	data, pos, err := parseConfigFile(path, string(b))
+	if err != nil {
+		ld.fail(path, err)
+		return layer, defined, nil
+	}
+	ld.stack = append(ld.stack, abs)
+	defer func() {
+		ld.stack = ld.stack[:len(ld.stack)-1]
+	}()
+
+	own := configLayer{data: data, pos: pos}
+	include, includeKey := own.take("include")
+	profiles, profilesKey := own.take("profiles")
+	for _, inc := range ld.include(path, own.pos, includeKey, include) {
+		layer.merge(inc.layer)
+		for name, p := range inc.defined {
+			mergeProfile(defined, name, p)
+		}
+	}
+	layer.merge(own)
+
+	profileData, _ := profiles.(map[string]interface{})
+	for _, name := range sortedKeys(profileData) {
+		key := joinKey([]string{profilesKey, name})
+		body, _ := profileData[name].(map[string]interface{})
+		p := own.sub(key, body)
+		if _, nested := p.take("profiles"); nested != "" {
+			ld.errorf(own.pos, path, joinKey([]string{key, nested}), "profiles cannot be nested")
+		}
+		include, includeKey := p.take("include")
+		includeKey = joinKey([]string{key, includeKey})
+		profile := newConfigLayer()
+		for _, inc := range ld.include(path, own.pos, includeKey, include) {
+			if len(inc.defined) > 0 {
+				ld.errorf(own.pos, path, includeKey,
+					"files included by a profile cannot define profiles")
+			}
+			profile.merge(inc.layer)
+		}
+		profile.merge(p)
+		mergeProfile(defined, name, profile)
+	}
+	return layer, defined, nil
+}
+
+// includedConfig is a config file that another includes.
+type includedConfig struct {
+	layer   configLayer
+	defined map[string]configLayer
+}
+
+// include loads the files matching each of the given include patterns, which
+// are relative to the file at the given path, in order. Files matching a
+// pattern are loaded in lexical order.
+func (ld *configLoader) include(
+	path string,
+	pos map[string]configPos,
+	key string,
+	patterns interface{},
+) []includedConfig {
+	list, _ := patterns.([]interface{})
+	var included []includedConfig
+	for i, p := range list {
+		k := key + index(i)
+		pattern, _ := p.(string)
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			ld.errorf(pos, path, k, "invalid pattern %q", p)
+			continue
+		}
+		if strings.ContainsAny(pattern, `*?[\`) {
+			// A new file matching the pattern is also a change.
+			if abs, err := filepath.Abs(pattern); err == nil {
+				ld.sources = append(ld.sources, abs)
+			}
+		} else if len(matches) == 0 {
+			ld.errorf(pos, path, k, "no such file %s", pattern)
+			continue
+		}
+		for _, m := range matches {
+			layer, defined, err := ld.load(m)
+			if err != nil {
+				ld.errorf(pos, path, k, "%v", err)
+				continue
+			}
+			included = append(included, includedConfig{layer: layer, defined: defined})
+		}
+	}
+	return included
+}
+
+// errorf records a problem with the given key, of the file at the given path.
+func (ld *configLoader) errorf(
+	pos map[string]configPos,
+	path string,
+	key string,
+	format string,
+	args ...interface{},
+) {
+	p := lookupPos(pos, key, path)
+	ld.errs = append(ld.errs, ConfigError{
+		Path: p.path,
+		Line: p.line,
+		Key:  key,
+		Msg:  fmt.Sprintf(format, args...),
+	})
+}
+
+// fail records the problems with the file at the given path.
+func (ld *configLoader) fail(path string, err error) {
+	if errs, ok := err.(ConfigErrors); ok {
+		ld.errs = append(ld.errs, errs...)
+		return
+	}
+	ld.errs = append(ld.errs, ConfigError{Path: path, Msg: err.Error()})
+}
+
+// mergeProfile merges the given layer of the named profile over any of the
+// same name already defined.
+func mergeProfile(defined map[string]configLayer, name string, p configLayer) {
+	if _, ok := defined[name]; !ok {
+		defined[name] = newConfigLayer()
+	}
+	defined[name].merge(p)
+}
+
+func sortedLayerNames(layers map[string]configLayer) []string {
+	var names []string
+	for name := range layers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,254 @@
+package watch
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMergeData(t *testing.T) {
+	tests := []struct {
+		name     string
+		dst, src map[string]interface{}
+		want     map[string]interface{}
+	}{
+		{
+			name: "tables are merged key by key",
+			dst: map[string]interface{}{
+				"triggers": map[string]interface{}{
+					"log":   map[string]interface{}{"doBuiltin": "log", "rateLimit": int64(5)},
+					"gmail": map[string]interface{}{"doShell": "notify"},
+				},
+			},
+			src: map[string]interface{}{
+				"triggers": map[string]interface{}{
+					"log": map[string]interface{}{"disabled": true},
+				},
+			},
+			want: map[string]interface{}{
+				"triggers": map[string]interface{}{
+					"log": map[string]interface{}{
+						"doBuiltin": "log",
+						"rateLimit": int64(5),
+						"disabled":  true,
+					},
+					"gmail": map[string]interface{}{"doShell": "notify"},
+				},
+			},
+		},
+		{
+			name: "anything else is replaced",
+			dst: map[string]interface{}{
+				"triggers": map[string]interface{}{
+					"log": map[string]interface{}{
+						"onEvents":  []interface{}{"host.new", "port.new"},
+						"rateLimit": int64(5),
+					},
+				},
+			},
+			src: map[string]interface{}{
+				"triggers": map[string]interface{}{
+					"log": map[string]interface{}{
+						"onEvents":  []interface{}{"host.lost"},
+						"rateLimit": "5/m",
+					},
+				},
+			},
+			want: map[string]interface{}{
+				"triggers": map[string]interface{}{
+					"log": map[string]interface{}{
+						"onEvents":  []interface{}{"host.lost"},
+						"rateLimit": "5/m",
+					},
+				},
+			},
+		},
+		{
+			name: "a table replaces a value that isn't one",
+			dst:  map[string]interface{}{"timing": "30s"},
+			src:  map[string]interface{}{"timing": map[string]interface{}{"host": "30s"}},
+			want: map[string]interface{}{"timing": map[string]interface{}{"host": "30s"}},
+		},
+		{
+			name: "exclusive trigger keys replace their group",
+			dst: map[string]interface{}{
+				"triggers": map[string]interface{}{
+					"log": map[string]interface{}{
+						"onEvents":  []interface{}{"host.new"},
+						"doBuiltin": "log",
+					},
+				},
+			},
+			src: map[string]interface{}{
+				"triggers": map[string]interface{}{
+					"log": map[string]interface{}{"DoShell": "notify"},
+				},
+			},
+			want: map[string]interface{}{
+				"triggers": map[string]interface{}{
+					"log": map[string]interface{}{
+						"onEvents": []interface{}{"host.new"},
+						"DoShell":  "notify",
+					},
+				},
+			},
+		},
+		{
+			name: "exclusive keys only apply to triggers",
+			dst: map[string]interface{}{
+				"other": map[string]interface{}{
+					"log": map[string]interface{}{"doBuiltin": "log"},
+				},
+			},
+			src: map[string]interface{}{
+				"other": map[string]interface{}{
+					"log": map[string]interface{}{"doShell": "notify"},
+				},
+			},
+			want: map[string]interface{}{
+				"other": map[string]interface{}{
+					"log": map[string]interface{}{"doBuiltin": "log", "doShell": "notify"},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		mergeData(nil, tt.dst, tt.src)
+		if !reflect.DeepEqual(tt.dst, tt.want) {
+			t.Errorf("%s: merged %#v, want %#v", tt.name, tt.dst, tt.want)
+		}
+	}
+}
+
+func TestMergeDataCopies(t *testing.T) {
+	dst := make(map[string]interface{})
+	src := map[string]interface{}{
+		"triggers": map[string]interface{}{
+			"log": map[string]interface{}{"onEvents": []interface{}{"host.new"}},
+		},
+	}
+	mergeData(nil, dst, src)
+	log := src["triggers"].(map[string]interface{})["log"].(map[string]interface{})
+	log["doBuiltin"] = "log"
+	log["onEvents"].([]interface{})[0] = "host.lost"
+
+	want := map[string]interface{}{
+		"triggers": map[string]interface{}{
+			"log": map[string]interface{}{"onEvents": []interface{}{"host.new"}},
+		},
+	}
+	if !reflect.DeepEqual(dst, want) {
+		t.Errorf("changing the merged layer changed the result to %#v", dst)
+	}
+}
+
+func TestLoadConfigIncludes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netwatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"config.toml": `include = ["triggers/*.toml"]
+
+[triggers.gmail]
+  disabled = true
+
+[profiles.prod.triggers.gmail]
+  disabled = false
+[profiles.prod.triggers.log]
+  onEvents = ["host.new"]
+
+[profiles.loud.triggers.log]
+  onAny = true
+`,
+		"triggers/a.toml": `[triggers.log]
+  onEvents = ["port.new"]
+  doBuiltin = "log"
+[triggers.gmail]
+  onEvents = ["host.new"]
+  doShell = "notify a"
+`,
+		"triggers/b.toml": `[triggers.gmail]
+  doShell = "notify b"
+`,
+	}
+	for name, text := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(dir, "config.toml")
+
+	tests := []struct {
+		profiles []string
+		want     map[string]TriggerSpec
+	}{
+		{
+			// Later includes take precedence, and the file itself
+			// over them.
+			profiles: nil,
+			want: map[string]TriggerSpec{
+				"log": {OnEvents: []EventType{PortNew}, DoBuiltin: "log"},
+				"gmail": {
+					Disabled: true,
+					OnEvents: []EventType{HostNew},
+					DoShell:  "notify b",
+				},
+			},
+		},
+		{
+			profiles: []string{"prod"},
+			want: map[string]TriggerSpec{
+				"log": {OnEvents: []EventType{HostNew}, DoBuiltin: "log"},
+				"gmail": {
+					OnEvents: []EventType{HostNew},
+					DoShell:  "notify b",
+				},
+			},
+		},
+		{
+			// Profiles are applied in the order given, and onAny
+			// replaces onEvents.
+			profiles: []string{"prod", "loud"},
+			want: map[string]TriggerSpec{
+				"log": {OnAny: true, DoBuiltin: "log"},
+				"gmail": {
+					OnEvents: []EventType{HostNew},
+					DoShell:  "notify b",
+				},
+			},
+		},
+		{
+			profiles: []string{"loud", "prod"},
+			want: map[string]TriggerSpec{
+				"log": {OnEvents: []EventType{HostNew}, DoBuiltin: "log"},
+				"gmail": {
+					OnEvents: []EventType{HostNew},
+					DoShell:  "notify b",
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		conf, err := LoadConfig(path, tt.profiles...)
+		if err != nil {
+			t.Errorf("profiles %v: failed to load: %v", tt.profiles, err)
+			continue
+		}
+		if !reflect.DeepEqual(conf.Triggers, tt.want) {
+			t.Errorf("profiles %v: loaded %+v, want %+v", tt.profiles, conf.Triggers, tt.want)
+		}
+	}
+
+	if _, err := LoadConfig(path, "nope"); err == nil {
+		t.Errorf("loaded unknown profile")
+	}
+}
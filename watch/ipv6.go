@@ -0,0 +1,267 @@
+package watch
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+// icmpv6OptRDNSS is the Recursive DNS Server option of RFC 8106, which isn't
+// named by gopacket.
+const icmpv6OptRDNSS layers.ICMPv6Opt = 25
+
+// Kinds of IPv6Addr.
+const (
+	IPv6LinkLocal = "link-local"
+	// IPv6SLAAC is an address whose interface identifier is the EUI-64
+	// form of the host's MAC address.
+	IPv6SLAAC = "slaac"
+	// IPv6Privacy is any other address, most often a temporary or stable
+	// privacy address, though it could also be assigned by DHCPv6.
+	IPv6Privacy = "privacy"
+)
+
+// IPv6Addr is one of possibly many IPv6 addresses used by a Host.
+type IPv6Addr struct {
+	IP       net.IP
+	Kind     string
+	Activity *Activity
+}
+
+func (a IPv6Addr) String() string {
+	return fmt.Sprintf("%s (%s)", a.IP, a.Kind)
+}
+
+// classifyIPv6 returns the kind of the given address, used by the host with
+// the given MAC.
+func classifyIPv6(ip net.IP, mac MAC) string {
+	if ip.IsLinkLocalUnicast() {
+		return IPv6LinkLocal
+	}
+	hw, err := net.ParseMAC(string(mac))
+	if err == nil && len(hw) == 6 && len(ip) == net.IPv6len {
+		eui := []byte{
+			hw[0] ^ 0x02, hw[1], hw[2], 0xff, 0xfe, hw[3], hw[4], hw[5],
+		}
+		if string(ip[8:]) == string(eui) {
+			return IPv6SLAAC
+		}
+	}
+	return IPv6Privacy
+}
+
+// IPv6Prefix is a prefix advertised by a router.
+type IPv6Prefix struct {
+	Prefix net.IPNet
+	// OnLink is whether addresses within the prefix are on the link, and
+	// Autonomous is whether hosts may use SLAAC within it.
+	OnLink            bool
+	Autonomous        bool
+	ValidLifetime     time.Duration
+	PreferredLifetime time.Duration
+}
+
+func (p IPv6Prefix) String() string {
+	return p.Prefix.String()
+}
+
+// RouterAdvertisement holds the contents of an ICMPv6 Router Advertisement.
+type RouterAdvertisement struct {
+	HopLimit uint8
+	// Managed and Other are whether hosts should use DHCPv6 for addresses
+	// and for other configuration respectively.
+	Managed bool
+	Other   bool
+	// Lifetime is how long the router may be used as a default router,
+	// where zero means that it isn't one.
+	Lifetime time.Duration
+	Prefixes []IPv6Prefix
+	// RDNSS are the advertised recursive DNS servers.
+	RDNSS []net.IP
+}
+
+func newRouterAdvertisement(ra *layers.ICMPv6RouterAdvertisement) *RouterAdvertisement {
+	adv := &RouterAdvertisement{
+		HopLimit: ra.HopLimit,
+		Managed:  ra.Flags&0x80 != 0,
+		Other:    ra.Flags&0x40 != 0,
+		Lifetime: time.Duration(ra.RouterLifetime) * time.Second,
+	}
+	for _, opt := range ra.Options {
+		switch opt.Type {
+		case layers.ICMPv6OptPrefixInfo:
+			if p, ok := parsePrefixInfo(opt.Data); ok {
+				adv.Prefixes = append(adv.Prefixes, p)
+			}
+		case icmpv6OptRDNSS:
+			adv.RDNSS = append(adv.RDNSS, parseRDNSS(opt.Data)...)
+		}
+	}
+	return adv
+}
+
+// parsePrefixInfo parses the data of a Prefix Information option, RFC 4861
+// section 4.6.2, excluding its type and length.
+func parsePrefixInfo(b []byte) (IPv6Prefix, bool) {
+	if len(b) < 30 {
+		return IPv6Prefix{}, false
+	}
+	bits := int(b[0])
+	if bits > 128 {
+		return IPv6Prefix{}, false
+	}
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, b[14:30])
+	mask := net.CIDRMask(bits, 128)
+	return IPv6Prefix{
+		Prefix:            net.IPNet{IP: ip.Mask(mask), Mask: mask},
+		OnLink:            b[1]&0x80 != 0,
+		Autonomous:        b[1]&0x40 != 0,
+		ValidLifetime:     time.Duration(binary.BigEndian.Uint32(b[2:6])) * time.Second,
+		PreferredLifetime: time.Duration(binary.BigEndian.Uint32(b[6:10])) * time.Second,
+	}, true
+}
+
+// parseRDNSS parses the data of a Recursive DNS Server option, RFC 8106
+// section 5.1, excluding its type and length.
+func parseRDNSS(b []byte) []net.IP {
+	var ips []net.IP
+	if len(b) < 6 {
+		return nil
+	}
+	for b = b[6:]; len(b) >= net.IPv6len; b = b[net.IPv6len:] {
+		ip := make(net.IP, net.IPv6len)
+		copy(ip, b[:net.IPv6len])
+		ips = append(ips, ip)
+	}
+	return ips
+}
+
+// ipv6Addrs returns the IPv6 addresses that the given view shows its host to
+// be using: those it claims by Neighbor Discovery, and its source address if
+// that is on-link. Others are routed through the host, such as by a router,
+// rather than being its own.
+func (w *Watcher) ipv6Addrs(v View) []net.IP {
+	addrs := v.IPv6Addrs
+	if w.isOnLink(v.IPv6) && !containsIP(addrs, v.IPv6) {
+		addrs = append(addrs[:len(addrs):len(addrs)], v.IPv6)
+	}
+	return addrs
+}
+
+// updateHostIPv6 touches each of the IPv6 addresses that the given view shows
+// its host to be using. Addresses are forgotten once they go unused for as long
+// as the host may, by the given timing.
+func (w *Watcher) updateHostIPv6(h *Host, v View, t Timing, now time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, ip := range w.ipv6Addrs(v) {
+		key := ip.String()
+		addr, ok := h.IPv6Addrs[key]
+		if !ok {
+			addr = &IPv6Addr{
+				IP:   ip,
+				Kind: classifyIPv6(ip, h.MAC),
+			}
+			addr.Activity = newActivity(w.clock, t.Host, func(a *Activity) {
+				w.mu.Lock()
+				defer w.mu.Unlock()
+				if h.IPv6Addrs[key] == addr {
+					delete(h.IPv6Addrs, key)
+				}
+				w.log.Debugf("lost ipv6 %s on %s", addr, h)
+			})
+			h.IPv6Addrs[key] = addr
+			w.log.Debugf("new ipv6 %s on %s", addr, h)
+		}
+		addr.Activity.SetTTL(t.Host)
+		addr.Activity.Touch(now)
+	}
+}
+
+// updateHostRouter records a Router Advertisement sent by the given host, if
+// the view has one.
+func (w *Watcher) updateHostRouter(h *Host, v View) {
+	if v.RA == nil {
+		return
+	}
+	isNew := h.RA == nil
+	h.RA = v.RA
+	if isNew {
+		w.emit(Event{
+			Type: RouterNew,
+			Body: EventRouterNew{h},
+		})
+	}
+	if reasons := w.conf.RA.check(h.MAC, v.RA); len(reasons) > 0 {
+		w.emit(Event{
+			Type: IPv6RogueRA,
+			Body: EventIPv6RogueRA{h, v.IPv6, v.RA, reasons},
+		})
+		return
+	}
+	for _, pre := range v.RA.Prefixes {
+		if pre.OnLink {
+			w.onLink.add(pre.Prefix)
+		}
+	}
+}
+
+// check returns each way in which the given Router Advertisement, sent by
+// the given MAC, breaks this policy.
+func (p RAPolicy) check(mac MAC, ra *RouterAdvertisement) []string {
+	var reasons []string
+	if len(p.AllowedRouters) > 0 {
+		if !containsMAC(p.AllowedRouters, mac) {
+			reasons = append(reasons, fmt.Sprintf("unlisted router %s", mac))
+		}
+		if ra.Lifetime == 0 {
+			reasons = append(reasons, "router lifetime of zero")
+		}
+	}
+	if len(p.AllowedPrefixes) > 0 {
+		for _, pre := range ra.Prefixes {
+			if !coversAny(p.AllowedPrefixes, pre.Prefix) {
+				reasons = append(reasons, fmt.Sprintf("unexpected prefix %s", pre))
+			}
+		}
+	}
+	if len(p.AllowedRDNSS) > 0 {
+		for _, ip := range ra.RDNSS {
+			if !containsIP(p.AllowedRDNSS, ip) {
+				reasons = append(reasons, fmt.Sprintf("unexpected rdnss %s", ip))
+			}
+		}
+	}
+	return reasons
+}
+
+func containsMAC(macs []MAC, mac MAC) bool {
+	for _, m := range macs {
+		if m == mac {
+			return true
+		}
+	}
+	return false
+}
+
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, i := range ips {
+		if i.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func coversAny(cidrs []CIDR, n net.IPNet) bool {
+	for _, c := range cidrs {
+		if c.Covers(n) {
+			return true
+		}
+	}
+	return false
+}
@@ -21,6 +21,9 @@ func handlePacket(
 		Dst:    NewView(),
 		Layers: make(map[gopacket.LayerType]int),
 	}
+	if md := packet.Metadata(); md != nil {
+		vp.Timestamp = md.Timestamp
+	}
 	for _, l := range packet.Layers() {
 		vp.Layers[l.LayerType()]++
 		switch l.LayerType() {
@@ -44,6 +47,31 @@ func handlePacket(
 			handleDHCPv4(&vp, l.(*layers.DHCPv4))
 		case layers.LayerTypeDHCPv6:
 			handleDHCPv6(&vp, l.(*layers.DHCPv6))
+		case layers.LayerTypeICMPv4:
+			handleICMPv4(&vp, l.(*layers.ICMPv4))
+		case layers.LayerTypeICMPv6:
+			// Other than echo and error messages, the message
+			// itself is decoded in the next layer.
+			handleICMPv6(&vp, l.(*layers.ICMPv6))
+		case layers.LayerTypeICMPv6Echo:
+			// Already handled by ICMPv6.
+		case layers.LayerTypeICMPv6RouterSolicitation:
+			// The source address is already handled by IPv6.
+		case layers.LayerTypeICMPv6RouterAdvertisement:
+			handleICMPv6RA(&vp, l.(*layers.ICMPv6RouterAdvertisement))
+		case layers.LayerTypeICMPv6NeighborSolicitation:
+			handleICMPv6NS(&vp, l.(*layers.ICMPv6NeighborSolicitation))
+		case layers.LayerTypeICMPv6NeighborAdvertisement:
+			handleICMPv6NA(&vp, l.(*layers.ICMPv6NeighborAdvertisement))
+		case layers.LayerTypeLinkLayerDiscovery:
+			handleLLDP(&vp, l.(*layers.LinkLayerDiscovery))
+		case layers.LayerTypeLinkLayerDiscoveryInfo:
+			handleLLDPInfo(&vp, l.(*layers.LinkLayerDiscoveryInfo))
+		case layers.LayerTypeCiscoDiscovery:
+			// The header only holds the version and TTL, the rest
+			// is in the CiscoDiscoveryInfo layer.
+		case layers.LayerTypeCiscoDiscoveryInfo:
+			handleCDPInfo(&vp, l.(*layers.CiscoDiscoveryInfo))
 		default:
 			log.Debugf("unhandled layer type: %v", l.LayerType())
 		}
@@ -57,24 +85,70 @@ func handleEthernet(v *ViewPair, eth *layers.Ethernet) {
 }
 
 func handleTCP(v *ViewPair, tcp *layers.TCP) {
-	v.Src.TCP[int(tcp.SrcPort)] = true
-	v.Dst.TCP[int(tcp.DstPort)] = true
+	v.TCP = tcp
 }
 
 func handleLCM(v *ViewPair, lcm *layers.LCM) {
 }
 
 func handleIPv4(v *ViewPair, ip4 *layers.IPv4) {
+	v.IPv4 = ip4
 	v.Src.IPv4 = ip4.SrcIP
 	v.Dst.IPv4 = ip4.DstIP
 }
 
 func handleIPv6(v *ViewPair, ip6 *layers.IPv6) {
+	v.IPv6 = ip6
 	v.Src.IPv6 = ip6.SrcIP
 	v.Dst.IPv6 = ip6.DstIP
 }
 
+func handleICMPv4(v *ViewPair, icmp *layers.ICMPv4) {
+	if m, ok := newICMPv4Message(icmp); ok {
+		v.Src.ICMP = m
+	}
+}
+
+func handleICMPv6(v *ViewPair, icmp *layers.ICMPv6) {
+	if m, ok := newICMPv6Message(icmp); ok {
+		v.Src.ICMP = m
+	}
+}
+
+func handleICMPv6RA(v *ViewPair, ra *layers.ICMPv6RouterAdvertisement) {
+	v.Src.RA = newRouterAdvertisement(ra)
+}
+
+func handleICMPv6NS(v *ViewPair, ns *layers.ICMPv6NeighborSolicitation) {
+	// A solicitation from the unspecified address is Duplicate Address
+	// Detection, where the target is the address that the sender is about
+	// to claim for itself.
+	if v.IPv6 != nil && v.IPv6.SrcIP.IsUnspecified() {
+		addIPv6Addr(&v.Src, ns.TargetAddress)
+	}
+}
+
+func handleICMPv6NA(v *ViewPair, na *layers.ICMPv6NeighborAdvertisement) {
+	// The target of an advertisement is the sender's own address.
+	addIPv6Addr(&v.Src, na.TargetAddress)
+}
+
+// addIPv6Addr adds the given address to those claimed by the given View, if it
+// is a unicast address.
+func addIPv6Addr(v *View, ip net.IP) {
+	if ip.To4() != nil || ip.IsUnspecified() || ip.IsMulticast() {
+		return
+	}
+	for _, prev := range v.IPv6Addrs {
+		if prev.Equal(ip) {
+			return
+		}
+	}
+	v.IPv6Addrs = append(v.IPv6Addrs, ip)
+}
+
 func handleUDP(v *ViewPair, udp *layers.UDP) {
+	v.UDP = udp
 	v.Src.UDP[int(udp.SrcPort)] = true
 	v.Dst.UDP[int(udp.DstPort)] = true
 }
@@ -83,6 +157,9 @@ func handleDNS(v *ViewPair, dns *layers.DNS) {
 }
 
 func handleDHCPv4(v *ViewPair, dhcp *layers.DHCPv4) {
+	if r, ok := newDHCPReply(dhcp); ok {
+		v.Src.DHCP = r
+	}
 	if dhcp.Operation == layers.DHCPOpRequest {
 		for _, opt := range dhcp.Options {
 			switch opt.Type {
@@ -121,7 +198,7 @@ func handleDHCPv6(v *ViewPair, dhcp *layers.DHCPv6) {
 }
 
 func handleARP(v *ViewPair, arp *layers.ARP) {
-	// TODO: Check for change.
+	v.ARP = arp
 	srcMAC := MAC(net.HardwareAddr(arp.SourceHwAddress).String())
 	dstMAC := MAC(net.HardwareAddr(arp.DstHwAddress).String())
 	v.Src.MAC = &srcMAC
@@ -130,3 +207,44 @@ func handleARP(v *ViewPair, arp *layers.ARP) {
 	addIP(&v.Src, net.IP(arp.SourceProtAddress))
 	addIP(&v.Dst, net.IP(arp.DstProtAddress))
 }
+
+func handleLLDP(v *ViewPair, lldp *layers.LinkLayerDiscovery) {
+	if v.Src.Neighbor == nil {
+		v.Src.Neighbor = &Neighbor{Protocol: "lldp"}
+	}
+	v.Src.Neighbor.ChassisID = lldpChassisID(lldp.ChassisID)
+	v.Src.Neighbor.PortID = lldpPortID(lldp.PortID)
+}
+
+func handleLLDPInfo(v *ViewPair, info *layers.LinkLayerDiscoveryInfo) {
+	if v.Src.Neighbor == nil {
+		v.Src.Neighbor = &Neighbor{Protocol: "lldp"}
+	}
+	n := v.Src.Neighbor
+	n.PortDescription = info.PortDescription
+	n.SystemName = info.SysName
+	n.Capabilities = lldpCapabilities(info.SysCapabilities.EnabledCap)
+	switch info.MgmtAddress.Subtype {
+	case layers.IANAAddressFamilyIPV4, layers.IANAAddressFamilyIPV6:
+		n.MgmtAddress = net.IP(info.MgmtAddress.Address)
+	}
+}
+
+func handleCDPInfo(v *ViewPair, info *layers.CiscoDiscoveryInfo) {
+	n := &Neighbor{
+		Protocol:     "cdp",
+		ChassisID:    info.DeviceID,
+		PortID:       info.PortID,
+		SystemName:   info.SysName,
+		Capabilities: cdpCapabilities(info.Capabilities),
+	}
+	if n.SystemName == "" {
+		n.SystemName = info.DeviceID
+	}
+	if len(info.MgmtAddresses) > 0 {
+		n.MgmtAddress = info.MgmtAddresses[0]
+	} else if len(info.Addresses) > 0 {
+		n.MgmtAddress = info.Addresses[0]
+	}
+	v.Src.Neighbor = n
+}
@@ -0,0 +1,236 @@
+package watch
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultDedupWindow is how long duplicate events are suppressed for, when a
+// trigger has a dedupKey but no dedupWindow.
+const defaultDedupWindow = time.Hour
+
+// suppressReportDelay is how long after an event is first suppressed that the
+// suppressed events are reported, if no event is allowed before then.
+const suppressReportDelay = time.Minute
+
+// Rate is a number of events per period, which can be decoded from text such
+// as 5/1h, or 5/h.
+type Rate struct {
+	N   int
+	Per time.Duration
+}
+
+// UnmarshalText satisfies the encoding.TextUnmarshaler interface.
+func (r *Rate) UnmarshalText(text []byte) error {
+	parts := strings.SplitN(string(text), "/", 2)
+	if len(parts) != 2 {
+		return errors.Errorf("invalid rate %q, expected e.g. 5/1h", text)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil || n <= 0 {
+		return errors.Errorf("invalid rate %q, expected a positive count", text)
+	}
+	per := strings.TrimSpace(parts[1])
+	if per != "" && (per[0] < '0' || per[0] > '9') {
+		per = "1" + per
+	}
+	d, err := time.ParseDuration(per)
+	if err != nil || d <= 0 {
+		return errors.Errorf("invalid rate %q, expected a positive period", text)
+	}
+	r.N, r.Per = n, d
+	return nil
+}
+
+// MarshalText satisfies the encoding.TextMarshaler interface.
+func (r Rate) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+func (r Rate) String() string {
+	return fmt.Sprintf("%d/%s", r.N, r.Per)
+}
+
+// tokenBucket allows bursts of up to its rate's count, refilled evenly over its
+// rate's period.
+type tokenBucket struct {
+	rate   Rate
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate Rate) *tokenBucket {
+	return &tokenBucket{rate: rate, tokens: float64(rate.N)}
+}
+
+// take returns whether a token was available at the given time, and if so
+// takes it.
+func (b *tokenBucket) take(now time.Time) bool {
+	if !b.last.IsZero() {
+		refill := now.Sub(b.last).Seconds() / b.rate.Per.Seconds() * float64(b.rate.N)
+		b.tokens += refill
+		if b.tokens > float64(b.rate.N) {
+			b.tokens = float64(b.rate.N)
+		}
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// triggerLimiter suppresses the events of one trigger that exceed its rate
+// limit, duplicate a recent event, or are within its cooldown. Suppressed
+// events are counted, and reported when an event is next allowed, or once
+// suppressReportDelay has passed.
+type triggerLimiter struct {
+	log  *logrus.Logger
+	name string
+
+	// mu guards the counts, which are also reported by a timer.
+	mu          sync.Mutex
+	reportTimer *time.Timer
+
+	bucket      *tokenBucket
+	dedup       *template.Template
+	dedupWindow time.Duration
+	seen        map[string]time.Time
+	seenAdds    int
+	cooldown    time.Duration
+	last        time.Time
+
+	limited    int
+	duplicates int
+	cooling    int
+}
+
+// newTriggerLimiter returns a limiter for the given trigger, or nil if it has
+// no limits.
+func newTriggerLimiter(
+	log *logrus.Logger,
+	name string,
+	spec TriggerSpec,
+) (*triggerLimiter, error) {
+	if spec.RateLimit.N == 0 && spec.DedupKey == "" && spec.Cooldown.Duration == 0 {
+		return nil, nil
+	}
+	l := &triggerLimiter{
+		log:         log,
+		name:        name,
+		dedupWindow: spec.DedupWindow.Duration,
+		seen:        make(map[string]time.Time),
+		cooldown:    spec.Cooldown.Duration,
+	}
+	if spec.RateLimit.N > 0 {
+		l.bucket = newTokenBucket(spec.RateLimit)
+	}
+	if spec.DedupKey != "" {
+		tmpl, err := template.New("").Parse(spec.DedupKey)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to template parse dedupKey: %s", spec.DedupKey)
+		}
+		l.dedup = tmpl
+		if l.dedupWindow == 0 {
+			l.dedupWindow = defaultDedupWindow
+		}
+	}
+	return l, nil
+}
+
+// Allow returns whether the given event should be passed on to the trigger,
+// at the given time.
+func (l *triggerLimiter) Allow(e Event, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.cooldown > 0 && !l.last.IsZero() && now.Sub(l.last) < l.cooldown {
+		l.cooling++
+		l.scheduleReport()
+		return false
+	}
+	var key string
+	if l.dedup != nil {
+		var buf bytes.Buffer
+		if err := l.dedup.Execute(&buf, newEventInfo(e)); err != nil {
+			l.log.WithError(err).Errorf("failed to execute dedupKey of trigger %s", l.name)
+		}
+		key = buf.String()
+		if last, ok := l.seen[key]; ok && now.Sub(last) < l.dedupWindow {
+			l.duplicates++
+			l.scheduleReport()
+			return false
+		}
+	}
+	if l.bucket != nil && !l.bucket.take(now) {
+		l.limited++
+		l.scheduleReport()
+		return false
+	}
+	if l.dedup != nil {
+		l.seen[key] = now
+		l.seenAdds++
+		if l.seenAdds%1024 == 0 {
+			l.prune(now)
+		}
+	}
+	l.last = now
+	l.report()
+	return true
+}
+
+// prune forgets dedup keys whose windows have passed.
+func (l *triggerLimiter) prune(now time.Time) {
+	for key, last := range l.seen {
+		if now.Sub(last) >= l.dedupWindow {
+			delete(l.seen, key)
+		}
+	}
+}
+
+// scheduleReport reports the suppressed events after suppressReportDelay, if
+// a report isn't already due.
+func (l *triggerLimiter) scheduleReport() {
+	if l.reportTimer != nil {
+		return
+	}
+	var t *time.Timer
+	t = time.AfterFunc(suppressReportDelay, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		// An event may have been allowed, and reported, since.
+		if l.reportTimer == t {
+			l.report()
+		}
+	})
+	l.reportTimer = t
+}
+
+// report logs how many events were suppressed since the last report, if any.
+func (l *triggerLimiter) report() {
+	if l.reportTimer != nil {
+		l.reportTimer.Stop()
+		l.reportTimer = nil
+	}
+	total := l.limited + l.duplicates + l.cooling
+	if total == 0 {
+		return
+	}
+	l.log.Infof(
+		"trigger %s suppressed %d events (%d rate limited, %d duplicates, %d cooling down)",
+		l.name,
+		total,
+		l.limited,
+		l.duplicates,
+		l.cooling,
+	)
+	l.limited, l.duplicates, l.cooling = 0, 0, 0
+}
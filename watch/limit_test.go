@@ -0,0 +1,231 @@
+package watch
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestRateUnmarshalText(t *testing.T) {
+	tests := []struct {
+		text string
+		want Rate
+		err  bool
+	}{
+		{text: "5/1h", want: Rate{5, time.Hour}},
+		{text: "5/h", want: Rate{5, time.Hour}},
+		{text: " 10 / 30s ", want: Rate{10, 30 * time.Second}},
+		{text: "5", err: true},
+		{text: "0/h", err: true},
+		{text: "-1/h", err: true},
+		{text: "5/0s", err: true},
+		{text: "5/fortnight", err: true},
+	}
+	for _, tt := range tests {
+		var r Rate
+		err := r.UnmarshalText([]byte(tt.text))
+		if tt.err {
+			if err == nil {
+				t.Errorf("%q decoded to %v, want an error", tt.text, r)
+			}
+			continue
+		}
+		if err != nil || r != tt.want {
+			t.Errorf("%q decoded to %v, %v, want %v", tt.text, r, err, tt.want)
+		}
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	t0 := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	b := newTokenBucket(Rate{N: 2, Per: time.Minute})
+	steps := []struct {
+		at   time.Duration
+		want bool
+	}{
+		// A burst of up to the count.
+		{0, true},
+		{0, true},
+		{time.Second, false},
+		// Refilled evenly, one token every 30s.
+		{29 * time.Second, false},
+		{31 * time.Second, true},
+		{32 * time.Second, false},
+		// But never beyond the count.
+		{time.Hour, true},
+		{time.Hour, true},
+		{time.Hour, false},
+	}
+	for i, s := range steps {
+		if got := b.take(t0.Add(s.at)); got != s.want {
+			t.Errorf("step %d: take at %s = %v, want %v", i, s.at, got, s.want)
+		}
+	}
+}
+
+// limitStep is an event of the given host passed to a limiter at the given
+// time, and whether it should be allowed.
+type limitStep struct {
+	mac  MAC
+	at   time.Duration
+	want bool
+}
+
+func TestTriggerLimiterAllow(t *testing.T) {
+	const a, b = MAC("00:00:5e:00:53:01"), MAC("00:00:5e:00:53:02")
+	tests := []struct {
+		name  string
+		spec  TriggerSpec
+		steps []limitStep
+		// limited, duplicates and cooling are the counts of suppressed
+		// events still to be reported after the last step.
+		limited, duplicates, cooling int
+	}{
+		{
+			name: "rate limit",
+			spec: TriggerSpec{RateLimit: Rate{N: 1, Per: time.Minute}},
+			steps: []limitStep{
+				{a, 0, true},
+				{b, time.Second, false},
+				{a, 59 * time.Second, false},
+				{a, 2 * time.Minute, true},
+				{a, 2 * time.Minute, false},
+			},
+			limited: 1,
+		},
+		{
+			name: "dedup window",
+			spec: TriggerSpec{
+				DedupKey:    "{{.Host.MAC}}",
+				DedupWindow: Duration{10 * time.Minute},
+			},
+			steps: []limitStep{
+				{a, 0, true},
+				{b, 0, true},
+				{a, time.Minute, false},
+				{a, 9 * time.Minute, false},
+				{b, 9 * time.Minute, false},
+				{a, 10 * time.Minute, true},
+				// The window is from when the key was last allowed.
+				{b, 10 * time.Minute, true},
+				{b, 11 * time.Minute, false},
+			},
+			duplicates: 1,
+		},
+		{
+			name: "default dedup window",
+			spec: TriggerSpec{DedupKey: "{{.Host.MAC}}"},
+			steps: []limitStep{
+				{a, 0, true},
+				{a, defaultDedupWindow - time.Second, false},
+				{a, defaultDedupWindow, true},
+			},
+		},
+		{
+			name: "cooldown",
+			spec: TriggerSpec{Cooldown: Duration{time.Minute}},
+			steps: []limitStep{
+				{a, 0, true},
+				{b, 30 * time.Second, false},
+				{a, 59 * time.Second, false},
+				{b, time.Minute, true},
+				{a, time.Minute + time.Second, false},
+			},
+			cooling: 1,
+		},
+		{
+			name: "duplicates don't use up the rate limit",
+			spec: TriggerSpec{
+				RateLimit: Rate{N: 2, Per: time.Hour},
+				DedupKey:  "{{.Host.MAC}}",
+			},
+			steps: []limitStep{
+				{a, 0, true},
+				{a, time.Second, false},
+				{a, 2 * time.Second, false},
+				{b, 3 * time.Second, true},
+				{b, 4 * time.Second, false},
+			},
+			duplicates: 1,
+		},
+	}
+	t0 := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	log := logrus.New()
+	log.Out = ioutil.Discard
+	for _, tt := range tests {
+		l, err := newTriggerLimiter(log, tt.name, tt.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, s := range tt.steps {
+			e, err := NewTestEvent(HostNew, s.mac, net.ParseIP("192.0.2.1"), 0, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := l.Allow(e, t0.Add(s.at)); got != s.want {
+				t.Errorf("%s: step %d: allowed %v at %s, want %v", tt.name, i, got, s.at, s.want)
+			}
+		}
+		l.mu.Lock()
+		if l.limited != tt.limited || l.duplicates != tt.duplicates || l.cooling != tt.cooling {
+			t.Errorf("%s: counted %d limited, %d duplicates, %d cooling, want %d, %d, %d",
+				tt.name, l.limited, l.duplicates, l.cooling,
+				tt.limited, tt.duplicates, tt.cooling)
+		}
+		l.report()
+		l.mu.Unlock()
+	}
+}
+
+func TestTriggerLimiterNone(t *testing.T) {
+	l, err := newTriggerLimiter(logrus.New(), "none", TriggerSpec{DoBuiltin: "log"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l != nil {
+		t.Errorf("got a limiter for a trigger without limits")
+	}
+}
+
+func TestTriggerLimiterReport(t *testing.T) {
+	var out bytes.Buffer
+	log := logrus.New()
+	log.Out = &out
+	l, err := newTriggerLimiter(log, "quiet", TriggerSpec{Cooldown: Duration{time.Minute}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewTestEvent(HostNew, "00:00:5e:00:53:01", net.ParseIP("192.0.2.1"), 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t0 := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	l.Allow(e, t0)
+	l.Allow(e, t0.Add(time.Second))
+	l.Allow(e, t0.Add(2*time.Second))
+	if out.Len() > 0 {
+		t.Errorf("reported before an event was next allowed: %s", out.String())
+	}
+	if l.reportTimer == nil {
+		t.Errorf("no report was scheduled for the suppressed events")
+	}
+
+	// The next allowed event reports those suppressed, and cancels the
+	// scheduled report.
+	l.Allow(e, t0.Add(time.Minute))
+	want := "trigger quiet suppressed 2 events (0 rate limited, 0 duplicates, 2 cooling down)"
+	if !strings.Contains(out.String(), want) {
+		t.Errorf("reported %q, want %q", out.String(), want)
+	}
+	if l.reportTimer != nil {
+		t.Errorf("report still scheduled after reporting")
+	}
+	if l.cooling != 0 {
+		t.Errorf("still counting %d events after reporting", l.cooling)
+	}
+}
@@ -0,0 +1,155 @@
+package watch
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/google/gopacket/layers"
+)
+
+// Neighbor holds what a host advertises about itself over a link layer
+// discovery protocol, LLDP or CDP. These are typically sent by switches,
+// routers and access points, to the hosts directly connected to them.
+type Neighbor struct {
+	// Protocol is either "lldp" or "cdp".
+	Protocol        string
+	ChassisID       string
+	PortID          string
+	PortDescription string
+	SystemName      string
+	// Capabilities are the enabled capabilities, e.g. "bridge" or "router".
+	Capabilities []string
+	MgmtAddress  net.IP
+}
+
+func (n Neighbor) String() string {
+	name := n.SystemName
+	if name == "" {
+		name = n.ChassisID
+	}
+	s := fmt.Sprintf("%s port %s", name, n.PortID)
+	if n.PortDescription != "" {
+		s += fmt.Sprintf(" (%s)", n.PortDescription)
+	}
+	return s
+}
+
+// IsInfrastructure returns whether the neighbor forwards traffic for others,
+// rather than being an end station. Neighbors that don't advertise any
+// capabilities are assumed to be infrastructure.
+func (n Neighbor) IsInfrastructure() bool {
+	if len(n.Capabilities) == 0 {
+		return true
+	}
+	for _, c := range n.Capabilities {
+		switch c {
+		case "bridge", "router", "switch", "wlan-ap", "repeater":
+			return true
+		}
+	}
+	return false
+}
+
+func (n Neighbor) equal(o Neighbor) bool {
+	return n.Protocol == o.Protocol &&
+		n.ChassisID == o.ChassisID &&
+		n.PortID == o.PortID &&
+		n.PortDescription == o.PortDescription &&
+		n.SystemName == o.SystemName &&
+		strings.Join(n.Capabilities, ",") == strings.Join(o.Capabilities, ",") &&
+		n.MgmtAddress.Equal(o.MgmtAddress)
+}
+
+// updateHostNeighbor records the discovery protocol advertisement, if any,
+// from the given view on its host.
+func (w *Watcher) updateHostNeighbor(h *Host, v View) {
+	if v.Neighbor == nil {
+		return
+	}
+	if h.Neighbor == nil || !h.Neighbor.equal(*v.Neighbor) {
+		w.log.Infof("neighbor %s is %s", h, v.Neighbor)
+	}
+	n := *v.Neighbor
+	h.Neighbor = &n
+	h.Infrastructure = n.IsInfrastructure()
+}
+
+func lldpChassisID(id layers.LLDPChassisID) string {
+	switch id.Subtype {
+	case layers.LLDPChassisIDSubTypeMACAddr:
+		return net.HardwareAddr(id.ID).String()
+	case layers.LLDPChassisIDSubTypeNetworkAddr:
+		return lldpNetworkAddr(id.ID)
+	}
+	return string(id.ID)
+}
+
+func lldpPortID(id layers.LLDPPortID) string {
+	switch id.Subtype {
+	case layers.LLDPPortIDSubtypeMACAddr:
+		return net.HardwareAddr(id.ID).String()
+	case layers.LLDPPortIDSubtypeNetworkAddr:
+		return lldpNetworkAddr(id.ID)
+	}
+	return string(id.ID)
+}
+
+// lldpNetworkAddr formats an address prefixed by its IANA address family.
+func lldpNetworkAddr(b []byte) string {
+	if len(b) > 0 {
+		switch layers.IANAAddressFamily(b[0]) {
+		case layers.IANAAddressFamilyIPV4, layers.IANAAddressFamilyIPV6:
+			return net.IP(b[1:]).String()
+		}
+	}
+	return fmt.Sprintf("%x", b)
+}
+
+func lldpCapabilities(c layers.LLDPCapabilities) []string {
+	var caps []string
+	for _, f := range []struct {
+		on   bool
+		name string
+	}{
+		{c.Other, "other"},
+		{c.Repeater, "repeater"},
+		{c.Bridge, "bridge"},
+		{c.WLANAP, "wlan-ap"},
+		{c.Router, "router"},
+		{c.Phone, "phone"},
+		{c.DocSis, "docsis"},
+		{c.StationOnly, "station"},
+		{c.CVLAN, "cvlan"},
+		{c.SVLAN, "svlan"},
+		{c.TMPR, "tpmr"},
+	} {
+		if f.on {
+			caps = append(caps, f.name)
+		}
+	}
+	return caps
+}
+
+func cdpCapabilities(c layers.CDPCapabilities) []string {
+	var caps []string
+	for _, f := range []struct {
+		on   bool
+		name string
+	}{
+		{c.L3Router, "router"},
+		{c.TBBridge, "bridge"},
+		{c.SPBridge, "bridge"},
+		{c.L2Switch, "switch"},
+		{c.IsHost, "host"},
+		{c.IGMPFilter, "igmp"},
+		{c.L1Repeater, "repeater"},
+		{c.IsPhone, "phone"},
+		{c.RemotelyManaged, "remote"},
+	} {
+		if f.on && (len(caps) == 0 || caps[len(caps)-1] != f.name) {
+			caps = append(caps, f.name)
+		}
+	}
+	return caps
+}
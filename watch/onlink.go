@@ -0,0 +1,68 @@
+package watch
+
+import (
+	"bytes"
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+// onLinkNets are the prefixes of the link being watched: those assigned to the
+// watched interface, and those that routers advertise as on-link.
+type onLinkNets struct {
+	nets []net.IPNet
+}
+
+func (o *onLinkNets) add(n net.IPNet) {
+	n = net.IPNet{IP: n.IP.Mask(n.Mask), Mask: n.Mask}
+	for _, prev := range o.nets {
+		if prev.IP.Equal(n.IP) && bytes.Equal(prev.Mask, n.Mask) {
+			return
+		}
+	}
+	o.nets = append(o.nets, n)
+}
+
+func (o *onLinkNets) contains(ip net.IP) bool {
+	for _, n := range o.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// addInterface adds the prefixes assigned to the interface of the given name.
+func (o *onLinkNets) addInterface(name string) error {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return errors.Wrapf(err, "failed to find interface %s", name)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return errors.Wrapf(err, "failed to list addresses of %s", name)
+	}
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok {
+			o.add(*n)
+		}
+	}
+	return nil
+}
+
+// isOnLink returns whether the given address is that of a host on the watched
+// link, rather than one whose packets are routed onto it. That is, whether
+// it's link-local, within an on-link prefix, or has been claimed over ARP.
+func (w *Watcher) isOnLink(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	if ip.IsLinkLocalUnicast() || w.onLink.contains(ip) {
+		return true
+	}
+	if ip.To4() != nil {
+		_, ok := w.arp[ip.String()]
+		return ok
+	}
+	return false
+}
@@ -0,0 +1,251 @@
+package watch
+
+import (
+	"strings"
+
+	"github.com/google/gopacket/layers"
+)
+
+// minOSConfidence is the lowest confidence at which an OS guess is recorded on
+// a Host. It is only reached when at least the option layout and TTL match.
+const minOSConfidence = 0.65
+
+// osChangeGuesses is how many guesses of a different OS in a row replace the
+// one recorded, however confident it was, such as after a host is reimaged or
+// its MAC address is reused.
+const osChangeGuesses = 3
+
+// tcpFingerprint holds the characteristics of a TCP SYN or SYN-ACK that vary
+// by operating system, in the spirit of p0f.
+type tcpFingerprint struct {
+	SYNACK bool
+	// TTL is the initial TTL, i.e. the observed TTL rounded up to the
+	// nearest common initial value.
+	TTL uint8
+	// Hops is the number of routers that the packet passed through, guessed
+	// from how far its TTL is below the initial TTL.
+	Hops   uint8
+	Window int
+	MSS    int
+	// Scale is the window scale, or -1 if the option wasn't present.
+	Scale int
+	// Options is the ordered layout of TCP options, e.g. "M,S,T,N,W".
+	Options string
+}
+
+// osSignature describes the fingerprint of one operating system. Zero valued
+// fields, other than OS and SYNACK, match anything.
+type osSignature struct {
+	OS     string
+	SYNACK bool
+	TTL    uint8
+	// Window is an exact window size, and WindowMSS a window size that is
+	// a multiple of the MSS.
+	Window    int
+	WindowMSS int
+	// Scale is the window scale, where -1 matches no window scale option,
+	// and 0 matches any.
+	Scale   int
+	Options string
+}
+
+// osSignatures is a small database of common operating system fingerprints,
+// adapted from p0f.
+var osSignatures = []osSignature{
+	// SYN
+	{OS: "Linux 3.11+", TTL: 64, WindowMSS: 20, Scale: 7, Options: "M,S,T,N,W"},
+	{OS: "Linux 3.11+", TTL: 64, WindowMSS: 20, Scale: 10, Options: "M,S,T,N,W"},
+	{OS: "Linux 2.6+", TTL: 64, WindowMSS: 10, Options: "M,S,T,N,W"},
+	{OS: "Linux 2.6+", TTL: 64, WindowMSS: 4, Options: "M,S,T,N,W"},
+	{OS: "Linux (Android)", TTL: 64, Window: 65535, Options: "M,S,T,N,W"},
+	{OS: "Windows 7/8/10", TTL: 128, Window: 8192, Scale: 8, Options: "M,N,W,N,N,S"},
+	{OS: "Windows 10", TTL: 128, Window: 64240, Scale: 8, Options: "M,N,W,N,N,S"},
+	{OS: "Windows 10", TTL: 128, Window: 65535, Scale: 8, Options: "M,N,W,N,N,S"},
+	{OS: "Windows XP", TTL: 128, Window: 65535, Scale: -1, Options: "M,N,N,S"},
+	{OS: "Windows XP", TTL: 128, Window: 64240, Scale: -1, Options: "M,N,N,S"},
+	{OS: "macOS/iOS", TTL: 64, Window: 65535, Scale: 6, Options: "M,N,W,N,N,T,S,E,E"},
+	{OS: "macOS/iOS", TTL: 64, Window: 65535, Scale: 5, Options: "M,N,W,N,N,T,S,E,E"},
+	{OS: "macOS/iOS", TTL: 64, Window: 65535, Scale: 6, Options: "M,N,W,S,T"},
+	{OS: "FreeBSD", TTL: 64, Window: 65535, Scale: 6, Options: "M,N,W,S,T"},
+	{OS: "OpenBSD", TTL: 64, Window: 16384, Scale: 3, Options: "M,N,N,S,N,W,N,N,T"},
+	{OS: "Solaris", TTL: 64, Window: 64240, Options: "N,N,T,M,N,W,N,N,S"},
+
+	// SYN-ACK
+	{OS: "Linux 3.x+", SYNACK: true, TTL: 64, WindowMSS: 20, Scale: 7, Options: "M,S,T,N,W"},
+	{OS: "Linux 3.x+", SYNACK: true, TTL: 64, WindowMSS: 10, Options: "M,S,T,N,W"},
+	{OS: "Linux 3.x+", SYNACK: true, TTL: 64, Options: "M,N,N,S,N,W"},
+	{OS: "Linux 3.x+", SYNACK: true, TTL: 64, Options: "M,S,N,W"},
+	{OS: "Windows 7/8/10", SYNACK: true, TTL: 128, Window: 8192, Scale: 8, Options: "M,N,W,N,N,S"},
+	{OS: "Windows 10", SYNACK: true, TTL: 128, Window: 65535, Scale: 8, Options: "M,N,W,N,N,S"},
+	{OS: "Windows XP", SYNACK: true, TTL: 128, Window: 65535, Scale: -1, Options: "M,N,N,S"},
+	{OS: "macOS/iOS", SYNACK: true, TTL: 64, Window: 65535, Options: "M,N,W,N,N,T,S"},
+	{OS: "macOS/iOS", SYNACK: true, TTL: 64, Window: 65535, Options: "M,N,W,S,T"},
+	{OS: "FreeBSD", SYNACK: true, TTL: 64, Window: 65535, Options: "M,N,W,S,T"},
+	{OS: "OpenBSD", SYNACK: true, TTL: 64, Window: 16384, Options: "M,N,N,S,N,W,N,N,T"},
+}
+
+// newTCPFingerprint returns the fingerprint of the given packet, if it is a
+// SYN or SYN-ACK.
+func newTCPFingerprint(vp ViewPair) (tcpFingerprint, bool) {
+	tcp := vp.TCP
+	if tcp == nil || !tcp.SYN {
+		return tcpFingerprint{}, false
+	}
+	var ttl uint8
+	switch {
+	case vp.IPv4 != nil:
+		ttl = vp.IPv4.TTL
+	case vp.IPv6 != nil:
+		ttl = vp.IPv6.HopLimit
+	default:
+		return tcpFingerprint{}, false
+	}
+	fp := tcpFingerprint{
+		SYNACK: tcp.ACK,
+		TTL:    initialTTL(ttl),
+		Hops:   initialTTL(ttl) - ttl,
+		Window: int(tcp.Window),
+		Scale:  -1,
+	}
+	var opts []string
+	for _, opt := range tcp.Options {
+		switch opt.OptionType {
+		case layers.TCPOptionKindEndList:
+			opts = append(opts, "E")
+		case layers.TCPOptionKindNop:
+			opts = append(opts, "N")
+		case layers.TCPOptionKindMSS:
+			opts = append(opts, "M")
+			if len(opt.OptionData) == 2 {
+				fp.MSS = int(opt.OptionData[0])<<8 | int(opt.OptionData[1])
+			}
+		case layers.TCPOptionKindWindowScale:
+			opts = append(opts, "W")
+			if len(opt.OptionData) == 1 {
+				fp.Scale = int(opt.OptionData[0])
+			}
+		case layers.TCPOptionKindSACKPermitted:
+			opts = append(opts, "S")
+		case layers.TCPOptionKindTimestamps:
+			opts = append(opts, "T")
+		default:
+			opts = append(opts, "?")
+		}
+	}
+	fp.Options = strings.Join(opts, ",")
+	return fp, true
+}
+
+// initialTTL guesses the TTL that a packet was sent with, given the TTL it
+// was received with after some number of hops.
+func initialTTL(ttl uint8) uint8 {
+	switch {
+	case ttl <= 32:
+		return 32
+	case ttl <= 64:
+		return 64
+	case ttl <= 128:
+		return 128
+	default:
+		return 255
+	}
+}
+
+// guessOS returns the operating system whose signature best matches the
+// given fingerprint, and the confidence of that match between 0 and 1.
+func guessOS(fp tcpFingerprint) (string, float64) {
+	var best string
+	var bestScore float64
+	for _, sig := range osSignatures {
+		if sig.SYNACK != fp.SYNACK {
+			continue
+		}
+		score := sig.score(fp)
+		if score > bestScore {
+			best, bestScore = sig.OS, score
+		}
+	}
+	return best, bestScore
+}
+
+// score returns how well the given fingerprint matches this signature,
+// between 0 and 1. The option layout is the most distinguishing, followed by
+// the TTL, window size and scale.
+func (sig osSignature) score(fp tcpFingerprint) float64 {
+	const (
+		wOptions = 0.4
+		wTTL     = 0.25
+		wWindow  = 0.2
+		wScale   = 0.15
+	)
+	var score float64
+	if sig.Options == fp.Options {
+		score += wOptions
+	}
+	if sig.TTL == 0 || sig.TTL == fp.TTL {
+		score += wTTL
+	}
+	switch {
+	case sig.Window != 0:
+		if sig.Window == fp.Window {
+			score += wWindow
+		}
+	case sig.WindowMSS != 0:
+		if fp.MSS > 0 && sig.WindowMSS*fp.MSS == fp.Window {
+			score += wWindow
+		}
+	default:
+		score += wWindow
+	}
+	if sig.Scale == 0 || sig.Scale == fp.Scale {
+		score += wScale
+	}
+	return score
+}
+
+// updateHostOS guesses the operating system of the given host, which sent
+// the given packet, and records it if it is a confident enough guess.
+//
+// Only packets sent by the host itself are fingerprinted. Those routed through
+// it, such as by a gateway, have its MAC address but come from another host,
+// so they must either not have passed through a router, or come from an
+// on-link address.
+func (w *Watcher) updateHostOS(h *Host, vp ViewPair) {
+	fp, ok := newTCPFingerprint(vp)
+	if !ok {
+		return
+	}
+	if fp.Hops > 0 && !w.isOnLink(vp.srcIP()) {
+		return
+	}
+	guess, conf := guessOS(fp)
+	if conf < minOSConfidence {
+		w.log.Debugf("unknown os for %s: %+v", h, fp)
+		return
+	}
+	if guess == h.OS {
+		h.osGuess, h.osGuesses = "", 0
+		if conf > h.OSConfidence {
+			h.OSConfidence = conf
+		}
+		return
+	}
+	// Only let a stronger guess replace another straight away, so that a
+	// host doesn't flap between equally likely guesses, e.g. when NATing
+	// for others. Otherwise it must be guessed repeatedly.
+	if guess != h.osGuess {
+		h.osGuess, h.osGuesses = guess, 0
+	}
+	h.osGuesses++
+	if conf <= h.OSConfidence && h.osGuesses < osChangeGuesses {
+		return
+	}
+	h.osGuess, h.osGuesses = "", 0
+	prev := h.OS
+	h.OS = guess
+	h.OSConfidence = conf
+	w.emit(Event{
+		Type: HostOSChanged,
+		Body: EventHostOSChanged{h, prev},
+	})
+}
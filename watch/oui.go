@@ -0,0 +1,119 @@
+package watch
+
+import (
+	"strings"
+)
+
+// ouiVendors is a small table of the vendors assigned some common OUIs, the
+// first three bytes of a MAC address, mostly of IoT devices, servers and
+// virtual machines.
+var ouiVendors = map[string]string{
+	// Raspberry Pi
+	"b8:27:eb": "Raspberry Pi",
+	"dc:a6:32": "Raspberry Pi",
+	"e4:5f:01": "Raspberry Pi",
+	"d8:3a:dd": "Raspberry Pi",
+	"28:cd:c1": "Raspberry Pi",
+
+	// Espressif, used by many IoT devices
+	"18:fe:34": "Espressif",
+	"24:0a:c4": "Espressif",
+	"24:6f:28": "Espressif",
+	"2c:3a:e8": "Espressif",
+	"30:ae:a4": "Espressif",
+	"3c:71:bf": "Espressif",
+	"5c:cf:7f": "Espressif",
+	"60:01:94": "Espressif",
+	"68:c6:3a": "Espressif",
+	"80:7d:3a": "Espressif",
+	"84:0d:8e": "Espressif",
+	"8c:aa:b5": "Espressif",
+	"a0:20:a6": "Espressif",
+	"a4:cf:12": "Espressif",
+	"bc:dd:c2": "Espressif",
+	"c4:4f:33": "Espressif",
+	"cc:50:e3": "Espressif",
+	"dc:4f:22": "Espressif",
+	"ec:fa:bc": "Espressif",
+
+	// Google and Nest
+	"3c:5a:b4": "Google",
+	"54:60:09": "Google",
+	"f4:f5:d8": "Google",
+	"f8:8f:ca": "Google",
+	"18:b4:30": "Nest",
+	"64:16:66": "Nest",
+
+	// Amazon
+	"0c:47:c9": "Amazon",
+	"44:65:0d": "Amazon",
+	"68:37:e9": "Amazon",
+	"74:c2:46": "Amazon",
+	"f0:27:2d": "Amazon",
+	"fc:65:de": "Amazon",
+
+	// Other consumer devices
+	"00:17:88": "Philips Hue",
+	"00:0e:58": "Sonos",
+	"5c:aa:fd": "Sonos",
+	"78:28:ca": "Sonos",
+	"94:9f:3e": "Sonos",
+	"b8:e9:37": "Sonos",
+	"b0:a7:37": "Roku",
+	"cc:6d:a0": "Roku",
+	"d8:31:34": "Roku",
+
+	// Network equipment
+	"00:15:6d": "Ubiquiti",
+	"00:27:22": "Ubiquiti",
+	"04:18:d6": "Ubiquiti",
+	"24:a4:3c": "Ubiquiti",
+	"44:d9:e7": "Ubiquiti",
+	"68:72:51": "Ubiquiti",
+	"74:83:c2": "Ubiquiti",
+	"78:8a:20": "Ubiquiti",
+	"80:2a:a8": "Ubiquiti",
+	"b4:fb:e4": "Ubiquiti",
+	"dc:9f:db": "Ubiquiti",
+	"f0:9f:c2": "Ubiquiti",
+	"fc:ec:da": "Ubiquiti",
+	"14:cc:20": "TP-Link",
+	"50:c7:bf": "TP-Link",
+	"98:da:c4": "TP-Link",
+	"f4:f2:6d": "TP-Link",
+	"00:18:0a": "Cisco Meraki",
+	"e0:55:3d": "Cisco Meraki",
+
+	// Servers and storage
+	"00:14:22": "Dell",
+	"18:03:73": "Dell",
+	"b8:ac:6f": "Dell",
+	"d4:ae:52": "Dell",
+	"f8:bc:12": "Dell",
+	"00:25:90": "Supermicro",
+	"0c:c4:7a": "Supermicro",
+	"ac:1f:6b": "Supermicro",
+	"00:11:32": "Synology",
+	"00:08:9b": "QNAP",
+	"24:5e:be": "QNAP",
+
+	// Virtual machines
+	"00:05:69": "VMware",
+	"00:0c:29": "VMware",
+	"00:1c:14": "VMware",
+	"00:50:56": "VMware",
+	"08:00:27": "VirtualBox",
+	"52:54:00": "QEMU",
+	"00:15:5d": "Hyper-V",
+	"00:16:3e": "Xen",
+}
+
+// lookupVendor returns the vendor assigned the OUI of the given MAC address, if
+// it is known.
+func lookupVendor(mac MAC) string {
+	s := strings.ToLower(string(mac))
+	if len(s) < 8 {
+		return ""
+	}
+	return ouiVendors[s[:8]]
+}
@@ -3,9 +3,7 @@ package watch
 import (
 	"fmt"
 	"net"
-	"sort"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/google/gopacket"
@@ -14,11 +12,8 @@ import (
 )
 
 var (
-	ttlHost     = 120 * time.Second
-	ttlPort     = 30 * time.Second
-	ttlArpScan  = 5 * time.Second
-	arpScanFreq = 20.0
-	arpWindow   = 10 * time.Second
+	ttlHost = 120 * time.Second
+	ttlPort = 30 * time.Second
 )
 
 // Activity holds episodic state for something.
@@ -29,16 +24,23 @@ type Activity struct {
 	LastSeen         time.Time
 
 	expireFunc func(a *Activity)
-	expire     *time.Timer
+	expire     expiryTimer
+	clock      clock
 	ttl        time.Duration
 }
 
 // NewActivity creates a new Activity with the given time-to-live, and callback
 // once it hasn't been touched after the given ttl.
 func NewActivity(ttl time.Duration, expireFunc func(a *Activity)) *Activity {
+	return newActivity(wallClock{}, ttl, expireFunc)
+}
+
+// newActivity is NewActivity, expiring by the given clock.
+func newActivity(c clock, ttl time.Duration, expireFunc func(a *Activity)) *Activity {
 	a := &Activity{
 		ttl:        ttl,
 		expireFunc: expireFunc,
+		clock:      c,
 	}
 	return a
 }
@@ -47,7 +49,7 @@ func NewActivity(ttl time.Duration, expireFunc func(a *Activity)) *Activity {
 // active.
 func (a *Activity) Touch(now time.Time) bool {
 	if a.expire == nil {
-		a.expire = time.AfterFunc(a.ttl, func() {
+		a.expire = a.clock.AfterFunc(a.ttl, func() {
 			a.IsActive = false
 			a.expireFunc(a)
 		})
@@ -68,30 +70,93 @@ func (a *Activity) Touch(now time.Time) bool {
 	return wasActive
 }
 
-// Age returns the time since this was first seen, regardless if it is
-// currently active or not.
+// SetTTL changes the time-to-live of this Activity. If it is active, it now
+// expires after the given ttl unless touched again.
+func (a *Activity) SetTTL(ttl time.Duration) {
+	a.ttl = ttl
+	if a.expire != nil && a.IsActive {
+		a.expire.Reset(ttl)
+	}
+}
+
+// Age returns the time from when this was first seen until it was last seen,
+// regardless if it is currently active or not.
 func (a Activity) Age() time.Duration {
-	return time.Since(a.FirstSeen)
+	return a.LastSeen.Sub(a.FirstSeen)
 }
 
-// Up returns the time since this was recently first seen.
+// Up returns the time from when this was recently first seen until it was
+// last seen.
 func (a Activity) Up() time.Duration {
-	return time.Since(a.FirstSeenEpisode)
+	return a.LastSeen.Sub(a.FirstSeenEpisode)
 }
 
 // Host is a tracked entity.
 type Host struct {
-	Activity        *Activity
+	Activity *Activity
+	// ActivityARPScan and ARPScan are nil until the host is first seen ARP
+	// scanning, after which ARPScan describes the latest scan.
 	ActivityARPScan *Activity
-
-	MAC      MAC
-	IPv4     net.IP
-	IPv6     net.IP
-	TCP      map[int]*Port
-	UDP      map[int]*Port
-	Hostname string
-
-	arps *windowed
+	ARPScan         *ARPScan
+	// ActivityPortScan and PortScan are nil until the host is first seen
+	// port scanning, after which PortScan describes the latest scan.
+	ActivityPortScan *Activity
+	PortScan         *PortScan
+	// ActivityPingSweep and ActivityTraceroute are similarly nil until
+	// first seen, and Traceroute describes the latest traced route.
+	ActivityPingSweep  *Activity
+	ActivityTraceroute *Activity
+	Traceroute         *Traceroute
+
+	MAC MAC
+	// Vendor is the vendor assigned the OUI of the MAC address, if known.
+	Vendor string
+	IPv4   net.IP
+	// IPv6 is the last IPv6 address used, and IPv6Addrs all of those
+	// used, keyed by their string form.
+	IPv6      net.IP
+	IPv6Addrs map[string]*IPv6Addr
+	// RA is the last IPv6 Router Advertisement sent by this host, if it is
+	// a router.
+	RA *RouterAdvertisement
+	// TCP holds the TCP ports that this host serves.
+	TCP map[int]*Port
+	// TCPRemote holds the TCP ports on other hosts that this host has
+	// connected to, keyed by their number, for as long as they're active.
+	TCPRemote map[int]*Port
+	UDP       map[int]*Port
+	Hostname  string
+
+	// Neighbor is what this host last advertised about itself over LLDP or
+	// CDP, if anything. Infrastructure is whether it is a switch, router,
+	// access point or the like.
+	Neighbor       *Neighbor
+	Infrastructure bool
+
+	// OS is the operating system passively inferred from the TCP packets
+	// this host sends, and OSConfidence is between 0 and 1.
+	OS           string
+	OSConfidence float64
+	// osGuess is a different OS than that recorded, which has been guessed
+	// osGuesses times in a row.
+	osGuess   string
+	osGuesses int
+
+	// HTTP counts plaintext HTTP requests made by this host for each
+	// requested domain and path without its query, e.g. example.com/foobar.
+	// UserAgents counts the User-Agent headers sent by this host. Each only
+	// keeps the most counted maxHostCounts keys.
+	HTTP       map[string]int
+	UserAgents map[string]int
+
+	// udpReplies holds when each UDP endpoint, e.g. 192.0.2.1:53, last
+	// sent to this host, so that its packets to them aren't probes.
+	udpReplies map[string]time.Time
+
+	arps   *probeWindow
+	probes *probeWindow
+	pings  *probeWindow
+	ttls   *probeWindow
 }
 
 func (h Host) String() string {
@@ -109,29 +174,33 @@ func (h Host) String() string {
 // default amount of time, indicating that it is non-active.
 func NewHost(
 	mac MAC,
-	events chan<- Event,
+	now time.Time,
+	expire func(h *Host),
+) *Host {
+	return newHost(wallClock{}, mac, now, expire)
+}
+
+// newHost is NewHost, expiring by the given clock.
+func newHost(
+	c clock,
+	mac MAC,
 	now time.Time,
 	expire func(h *Host),
 ) *Host {
 	h := Host{
-		MAC:  mac,
-		TCP:  make(map[int]*Port),
-		UDP:  make(map[int]*Port),
-		arps: newWindowed(arpWindow),
+		MAC:        mac,
+		Vendor:     lookupVendor(mac),
+		TCP:        make(map[int]*Port),
+		IPv6Addrs:  make(map[string]*IPv6Addr),
+		TCPRemote:  make(map[int]*Port),
+		UDP:        make(map[int]*Port),
+		HTTP:       make(map[string]int),
+		UserAgents: make(map[string]int),
 	}
-	h.Activity = NewActivity(ttlHost, func(a *Activity) {
+	h.Activity = newActivity(c, ttlHost, func(a *Activity) {
 		expire(&h)
 	})
 	h.Activity.Touch(now)
-	h.ActivityARPScan = NewActivity(ttlArpScan, func(a *Activity) {
-		events <- Event{
-			Type: HostARPScanStop,
-			Body: EventHostARPScanStop{
-				Host: &h,
-				Up:   time.Since(a.FirstSeenEpisode),
-			},
-		}
-	})
 	return &h
 }
 
@@ -171,6 +240,10 @@ func (h Host) ActiveUDP() []*Port {
 type Port struct {
 	Activity *Activity
 	Num      int
+	Role     PortRole
+	// Remote is the IP address of the host first connected to on this
+	// port, when its Role is PortClient.
+	Remote net.IP
 
 	isTCP bool
 }
@@ -181,30 +254,76 @@ func NewPortTCP(
 	num int,
 	now time.Time,
 	expire func(p *Port),
+) *Port {
+	return newPortTCP(wallClock{}, num, now, expire)
+}
+
+// newPortTCP is NewPortTCP, expiring by the given clock.
+func newPortTCP(
+	c clock,
+	num int,
+	now time.Time,
+	expire func(p *Port),
 ) *Port {
 	p := Port{
 		Num:   num,
 		isTCP: true,
 	}
-	p.Activity = NewActivity(ttlHost, func(a *Activity) {
+	p.Activity = newActivity(c, ttlPort, func(a *Activity) {
 		expire(&p)
 	})
 	p.Activity.Touch(now)
 	return &p
 }
 
+// NewPortTCPRemote returns a new TCP port, of the given port number served by
+// the given remote IP, that a host has connected to. And a function one what
+// to do when the port expires, given a pointer to the created Port.
+func NewPortTCPRemote(
+	num int,
+	remote net.IP,
+	now time.Time,
+	expire func(p *Port),
+) *Port {
+	return newPortTCPRemote(wallClock{}, num, remote, now, expire)
+}
+
+// newPortTCPRemote is NewPortTCPRemote, expiring by the given clock.
+func newPortTCPRemote(
+	c clock,
+	num int,
+	remote net.IP,
+	now time.Time,
+	expire func(p *Port),
+) *Port {
+	p := newPortTCP(c, num, now, expire)
+	p.Role = PortClient
+	p.Remote = remote
+	return p
+}
+
 // NewPortUDP returns a new UDP port of the given port number. And a function
 // one what to do when the port expires, given a pointer to the created Port.
 func NewPortUDP(
 	num int,
 	now time.Time,
 	expire func(p *Port),
+) *Port {
+	return newPortUDP(wallClock{}, num, now, expire)
+}
+
+// newPortUDP is NewPortUDP, expiring by the given clock.
+func newPortUDP(
+	c clock,
+	num int,
+	now time.Time,
+	expire func(p *Port),
 ) *Port {
 	p := Port{
 		Num:   num,
 		isTCP: false,
 	}
-	p.Activity = NewActivity(ttlHost, func(a *Activity) {
+	p.Activity = newActivity(c, ttlPort, func(a *Activity) {
 		expire(&p)
 	})
 	p.Activity.Touch(now)
@@ -223,10 +342,31 @@ func (p Port) String() string {
 
 }
 
+// At describes where this Port is relative to the given Host that it belongs
+// to, e.g. "22/tcp at 192.168.86.4" for a port that the host serves, or
+// "22/tcp on 10.0.0.1 from 192.168.86.4" for one that it connected to.
+func (p Port) At(h Host) string {
+	if p.Role == PortClient {
+		return fmt.Sprintf("%s on %s from %s", p, p.Remote, h.IPv4)
+	}
+	return fmt.Sprintf("%s at %s", p, h.IPv4)
+}
+
 // MAC is a string form of a net.HardwareAddr, so as to be used as keys in
 // maps.
 type MAC string
 
+// UnmarshalText satisfies the encoding.TextUnmarshaler interface, so that
+// MACs given in any format are normalized to match those seen in packets.
+func (m *MAC) UnmarshalText(text []byte) error {
+	hw, err := net.ParseMAC(string(text))
+	if err != nil {
+		return err
+	}
+	*m = MAC(hw.String())
+	return nil
+}
+
 // View represents a subset of information depicted about a Host, from a single
 // packet. This can be used to be associate with a host, and update it's
 // information. A View's properties are intended to be updated as different
@@ -234,19 +374,31 @@ type MAC string
 // View, but it aims to capture as much information from each packet as
 // possible before updating the hosts.
 type View struct {
-	MAC      *MAC
-	IPv4     net.IP
-	IPv6     net.IP
-	TCP      map[int]bool
-	UDP      map[int]bool
-	Hostname string
+	MAC  *MAC
+	IPv4 net.IP
+	IPv6 net.IP
+	// IPv6Addrs are the IPv6 addresses claimed by Neighbor Discovery,
+	// including Duplicate Address Detection.
+	IPv6Addrs []net.IP
+	RA        *RouterAdvertisement
+	// TCP holds ports served, and TCPRemote the ports connected to on
+	// another host.
+	TCP       map[int]bool
+	TCPRemote map[int]Endpoint
+	UDP       map[int]bool
+	Hostname  string
+	Neighbor  *Neighbor
+	// DHCP is the DHCP offer or acknowledgement sent, if any.
+	DHCP *DHCPReply
+	ICMP *ICMPMessage
 }
 
 // NewView returns a new
 func NewView() View {
 	return View{
-		TCP: make(map[int]bool),
-		UDP: make(map[int]bool),
+		TCP:       make(map[int]bool),
+		TCPRemote: make(map[int]Endpoint),
+		UDP:       make(map[int]bool),
 	}
 }
 
@@ -256,6 +408,36 @@ type ViewPair struct {
 	Src    View
 	Dst    View
 	Layers map[gopacket.LayerType]int
+	// Timestamp is when the packet was captured, if known.
+	Timestamp time.Time
+	// TCP is the TCP layer of the packet, if any. Which of its ports are
+	// served is decided by the Watcher, since it depends on packets
+	// previously seen.
+	TCP  *layers.TCP
+	IPv4 *layers.IPv4
+	IPv6 *layers.IPv6
+	ARP  *layers.ARP
+	UDP  *layers.UDP
+}
+
+// Time returns when the packet was captured, or the given time if that isn't
+// known.
+func (vp ViewPair) Time(now time.Time) time.Time {
+	if vp.Timestamp.IsZero() {
+		return now
+	}
+	return vp.Timestamp
+}
+
+// srcIP returns the source address of the packet's IP layer, if any.
+func (vp ViewPair) srcIP() net.IP {
+	switch {
+	case vp.IPv4 != nil:
+		return vp.IPv4.SrcIP
+	case vp.IPv6 != nil:
+		return vp.IPv6.SrcIP
+	}
+	return nil
 }
 
 // ScanPackets updates hosts with a given a stream of packets, and sends
@@ -267,12 +449,58 @@ func (w *Watcher) ScanPackets(
 	packets <-chan gopacket.Packet,
 ) {
 	defer close(w.events)
+	asm := newStreamAssembler(&streamFactory{
+		parsers: w.streamParsers,
+		report: func(mac MAC, result interface{}) {
+			w.updateHostWithStream(hosts, mac, result)
+		},
+	}, w.conf.Timing.streamTTL())
+	defer asm.Close()
 	for p := range packets {
 		vp := handlePacket(w.log, p)
-		w.updateHosts(vp, hosts)
+		now := vp.Time(w.clock.Now())
+		w.clock.advance(now)
+		w.updateHosts(vp, hosts, now)
+		asm.Assemble(p)
 	}
 }
 
+// updateHostWithStream records a result parsed from a TCP stream, which is
+// attributed to the host with the given MAC.
+func (w *Watcher) updateHostWithStream(
+	hosts map[MAC]*Host,
+	mac MAC,
+	result interface{},
+) {
+	switch r := result.(type) {
+	case HTTPTransaction:
+		w.updateHostWithHTTP(hosts, mac, r)
+	default:
+		w.log.Debugf("unhandled stream result: %#v", result)
+	}
+}
+
+// updateHostWithHTTP records a plaintext HTTP request made by the host with
+// the given MAC.
+func (w *Watcher) updateHostWithHTTP(
+	hosts map[MAC]*Host,
+	mac MAC,
+	tx HTTPTransaction,
+) {
+	h, ok := hosts[mac]
+	if !ok {
+		return
+	}
+	countBounded(h.HTTP, tx.ResourceNoQuery(), maxHostCounts)
+	if tx.UserAgent != "" {
+		countBounded(h.UserAgents, tx.UserAgent, maxHostCounts)
+	}
+	w.emit(Event{
+		Type: HTTPRequest,
+		Body: EventHTTPRequest{h, tx},
+	})
+}
+
 // Consider using a graph database for storing all directed interactions
 // between the hosts.
 
@@ -283,8 +511,10 @@ var InvalidHost = Host{}
 func (w *Watcher) updateHosts(
 	vp ViewPair,
 	hosts map[MAC]*Host,
+	now time.Time,
 ) {
-	w.updateHostWithView(hosts, vp, vp.Src)
+	w.inferTCPRoles(&vp, now)
+	w.updateHostWithView(hosts, vp, vp.Src, now)
 	// TODO: There are some bugs here with the double updating, with
 	// duplicate new hosts being detected.
 	//
@@ -295,9 +525,8 @@ func (w *Watcher) updateHostWithView(
 	hosts map[MAC]*Host,
 	vp ViewPair,
 	v View,
+	now time.Time,
 ) {
-	now := time.Now()
-
 	// TODO: Relieve this handicap, which is an artifact of the hosts
 	// map[MAC]*Host datastructure, which should be made more
 	// flexible.
@@ -306,36 +535,37 @@ func (w *Watcher) updateHostWithView(
 	}
 
 	prev := findHost(v, hosts)
+	t := w.timingFor(*v.MAC, v, prev)
 	var curr *Host
 	if prev == nil {
-		curr = NewHost(*v.MAC, w.events, now, func(h *Host) {
-			up := time.Since(h.Activity.FirstSeenEpisode)
-			w.events <- Event{
+		curr = newHost(w.clock, *v.MAC, now, func(h *Host) {
+			w.emit(Event{
 				Type: HostLost,
-				Body: EventHostLost{h, up},
-			}
+				Body: EventHostLost{h, h.Activity.Up()},
+			})
 		})
+		curr.Activity.SetTTL(t.Host)
 		hosts[*v.MAC] = curr
-		w.events <- Event{
+		w.emit(Event{
 			Type: HostNew,
 			Body: EventHostNew{curr},
-		}
+		})
 	} else {
-		if time.Since(prev.Activity.LastSeen) > ttlHost {
-			down := time.Since(prev.Activity.LastSeen)
-			w.events <- Event{
+		if now.Sub(prev.Activity.LastSeen) > t.Host {
+			down := now.Sub(prev.Activity.LastSeen)
+			w.emit(Event{
 				Type: HostFound,
 				Body: EventHostFound{prev, down},
-			}
+			})
 		}
 		curr = prev
-		// TODO: Add timestamp arg to Touch method.
+		curr.Activity.SetTTL(t.Host)
 		curr.Activity.Touch(now)
 		w.log.Debugf("touch host %s", curr)
-		w.events <- Event{
+		w.emit(Event{
 			Type: HostTouch,
 			Body: EventHostTouch{curr},
-		}
+		})
 	}
 
 	if v.Hostname != "" {
@@ -345,20 +575,16 @@ func (w *Watcher) updateHostWithView(
 		curr.Hostname = v.Hostname
 	}
 
-	// Update ARP scan.
-	if vp.Layers[layers.LayerTypeARP] > 0 {
-		// TODO: Use a View timestamp.
-		curr.arps.Add(now)
-	}
-	freq := curr.arps.Freq()
-	if freq >= arpScanFreq {
-		if !curr.ActivityARPScan.Touch(now) {
-			w.events <- Event{
-				Type: HostARPScanStart,
-				Body: EventHostARPScanStart{curr},
-			}
-		}
-	}
+	w.updateHostNeighbor(curr, v)
+	w.updateHostOS(curr, vp)
+
+	w.updateARPBindings(hosts, curr, vp, t, now)
+
+	w.updatePortScan(hosts, curr, vp, now)
+	w.updateICMP(hosts, curr, vp, now)
+	w.updateBeacons(curr, vp, now)
+
+	w.updateARPScan(curr, vp, now)
 
 	// TODO: Display differences, which may be a job for
 	// findHost.
@@ -374,77 +600,123 @@ func (w *Watcher) updateHostWithView(
 		}
 		curr.IPv6 = v.IPv6
 	}
+	w.updateHostIPv6(curr, v, t, now)
+	w.updateIPConflicts(hosts, curr, v, t, now)
+	w.updateHostRouter(curr, v)
+	w.updateDHCPServers(curr, v, now)
 
-	w.updatePortsWithView(curr, v)
+	w.updatePortsWithView(curr, v, t, now)
 }
 
-// TODO: Only update dst ports whenever the dst host is active.
-func (w *Watcher) updatePortsWithView(h *Host, v View) {
-	now := time.Now()
+// updateRemotePort records that the given host connected to the given remote
+// port. Remote ports are forgotten once they expire, and no more than
+// maxRemotePorts are tracked at once.
+func (w *Watcher) updateRemotePort(h *Host, num int, remote Endpoint, t Timing, now time.Time) {
+	w.mu.Lock()
+	if prev, ok := h.TCPRemote[num]; ok {
+		prev.Activity.SetTTL(t.Port)
+		prev.Activity.Touch(now)
+		w.mu.Unlock()
+		w.log.Debugf("touch remote %s on %s from %s", prev, remote, h.IPv4)
+		return
+	}
+	if len(h.TCPRemote) >= maxRemotePorts {
+		w.mu.Unlock()
+		w.log.Debugf("not tracking remote %s from %s, too many ports", remote, h.IPv4)
+		return
+	}
+	curr := newPortTCPRemote(w.clock, num, remote.IP, now, func(p *Port) {
+		w.mu.Lock()
+		if h.TCPRemote[num] == p {
+			delete(h.TCPRemote, num)
+		}
+		w.mu.Unlock()
+		w.emit(Event{
+			Type: PortLost,
+			Body: EventPortLost{p, p.Activity.Up(), h},
+		})
+	})
+	curr.Activity.SetTTL(t.Port)
+	h.TCPRemote[num] = curr
+	w.mu.Unlock()
+	w.emit(Event{
+		Type: PortNew,
+		Body: EventPortNew{curr, h},
+	})
+}
 
+// TODO: Only update dst ports whenever the dst host is active.
+func (w *Watcher) updatePortsWithView(h *Host, v View, t Timing, now time.Time) {
 	for num := range v.TCP {
 		prev, ok := h.TCP[num]
 		var curr *Port
 		if !ok {
-			curr = NewPortTCP(num, now, func(p *Port) {
-				w.events <- Event{
+			curr = newPortTCP(w.clock, num, now, func(p *Port) {
+				w.emit(Event{
 					Type: PortLost,
 					Body: EventPortLost{p, p.Activity.Up(), h},
-				}
+				})
 			})
+			curr.Activity.SetTTL(t.Port)
 			h.TCP[num] = curr
-			w.events <- Event{
+			w.emit(Event{
 				Type: PortNew,
 				Body: EventPortNew{curr, h},
-			}
+			})
 		} else {
-			if time.Since(prev.Activity.LastSeen) > ttlPort {
+			if now.Sub(prev.Activity.LastSeen) > t.Port {
 				// We consider the host to have been alive for
-				// ttlPort nanoseconds after it was last seen.
-				down := time.Since(prev.Activity.LastSeen) - ttlPort
-				w.events <- Event{
+				// t.Port nanoseconds after it was last seen.
+				down := now.Sub(prev.Activity.LastSeen) - t.Port
+				w.emit(Event{
 					Type: PortFound,
 					Body: EventPortFound{prev, down, h},
-				}
+				})
 			}
 			curr = prev
+			curr.Activity.SetTTL(t.Port)
 			curr.Activity.Touch(now)
 			w.log.Debugf("touch host %s on %s", curr, h.IPv4)
 		}
 
 	}
+	for num, remote := range v.TCPRemote {
+		w.updateRemotePort(h, num, remote, t, now)
+	}
 	for num := range v.UDP {
 		prev, ok := h.UDP[num]
 		var curr *Port
 		if !ok {
-			curr = NewPortUDP(num, now, func(p *Port) {
-				w.events <- Event{
+			curr = newPortUDP(w.clock, num, now, func(p *Port) {
+				w.emit(Event{
 					Type: PortLost,
 					Body: EventPortLost{p, p.Activity.Up(), h},
-				}
+				})
 			})
+			curr.Activity.SetTTL(t.Port)
 			h.UDP[num] = curr
-			w.events <- Event{
+			w.emit(Event{
 				Type: PortNew,
 				Body: EventPortNew{curr, h},
-			}
+			})
 		} else {
-			if time.Since(prev.Activity.LastSeen) > ttlPort {
+			if now.Sub(prev.Activity.LastSeen) > t.Port {
 				// We consider the host to have been alive for
-				// ttlPort nanoseconds after it was last seen.
-				down := time.Since(prev.Activity.LastSeen) - ttlPort
-				w.events <- Event{
+				// t.Port nanoseconds after it was last seen.
+				down := now.Sub(prev.Activity.LastSeen) - t.Port
+				w.emit(Event{
 					Type: PortFound,
 					Body: EventPortFound{prev, down, h},
-				}
+				})
 			}
 			curr = prev
+			curr.Activity.SetTTL(t.Port)
 			curr.Activity.Touch(now)
 			w.log.Debugf("touch host %s on %s", curr, h.IPv4)
-			w.events <- Event{
+			w.emit(Event{
 				Type: PortTouch,
 				Body: EventPortTouch{curr, h},
-			}
+			})
 		}
 	}
 }
@@ -469,43 +741,3 @@ func addIP(v *View, ip net.IP) {
 		util.NewLogger().Warnf("invalid ip len=%d: %#v", len(ip), ip)
 	}
 }
-
-type windowed struct {
-	size    time.Duration
-	mu      sync.Mutex
-	entries []time.Time
-}
-
-func newWindowed(size time.Duration) *windowed {
-	return &windowed{size: size}
-}
-
-// Add adds an entry with the given timestamp.
-func (w *windowed) Add(ts time.Time) {
-	w.mu.Lock()
-	w.entries = append(w.entries, ts)
-	if len(w.entries)%50 == 0 {
-		w.flush()
-	}
-	w.mu.Unlock()
-}
-
-func (w *windowed) flush() {
-	now := time.Now()
-	cut := now.Add(-w.size)
-	i := sort.Search(len(w.entries), func(i int) bool {
-		return w.entries[i].After(cut)
-	})
-	w.entries = w.entries[i:]
-}
-
-// Count returns the nubmer of entries in the window size.
-func (w *windowed) Count() int {
-	w.flush()
-	return len(w.entries)
-}
-
-// Freq returns the current Count per second.
-func (w *windowed) Freq() float64 {
-	return float64(w.Count()) / w.size.Seconds()
-}
@@ -0,0 +1,301 @@
+package watch
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// Default thresholds of PortScanConfig.
+const (
+	defaultPortScanWindow  = 10 * time.Second
+	defaultPortScanPorts   = 20
+	defaultPortScanTargets = 64
+	defaultPortScanTTL     = 10 * time.Second
+)
+
+// UDP replies are remembered for udpReplyTTL, and for at most maxUDPReplies
+// endpoints per host.
+const (
+	udpReplyTTL   = 10 * time.Minute
+	maxUDPReplies = 1024
+)
+
+// PortScanConfig holds the thresholds for detecting port scans. A host is
+// scanning once it probes at least Ports distinct ports on one target, or the
+// same port on at least Targets distinct targets, within Window. The scan
+// stops once it hasn't probed enough for TTL. UDP packets to a port that has
+// recently sent UDP to the host aren't counted, since they're more likely
+// replies or requests to a known service than probes. Zero values use
+// defaults.
+type PortScanConfig struct {
+	Window  Duration
+	Ports   int
+	Targets int
+	TTL     Duration
+}
+
+func (c PortScanConfig) withDefaults() PortScanConfig {
+	if c.Window.Duration <= 0 {
+		c.Window.Duration = defaultPortScanWindow
+	}
+	if c.Ports <= 0 {
+		c.Ports = defaultPortScanPorts
+	}
+	if c.Targets <= 0 {
+		c.Targets = defaultPortScanTargets
+	}
+	if c.TTL.Duration <= 0 {
+		c.TTL.Duration = defaultPortScanTTL
+	}
+	return c
+}
+
+// Types of PortScan, by the probes sent.
+const (
+	PortScanSYN  = "syn"
+	PortScanFIN  = "fin"
+	PortScanNULL = "null"
+	PortScanXmas = "xmas"
+	PortScanUDP  = "udp"
+)
+
+// PortScan describes a port scan performed by a host.
+type PortScan struct {
+	// Type is the type of probe that started the scan, e.g. "syn".
+	Type string
+	// Sweep is whether the scan is across many targets for the same port,
+	// rather than across many ports of the same target.
+	Sweep bool
+	// Ports and Targets are the most distinct ports probed on one target,
+	// and targets probed on one port, within the window.
+	Ports   int
+	Targets int
+}
+
+func (s PortScan) String() string {
+	kind := "vertical"
+	if s.Sweep {
+		kind = "horizontal"
+	}
+	return fmt.Sprintf(
+		"%s %s scan of %d ports on %d targets",
+		kind,
+		s.Type,
+		s.Ports,
+		s.Targets,
+	)
+}
+
+// probe is a packet that may be part of a port scan.
+type probe struct {
+	at     time.Time
+	target string
+	// port is a port and protocol, e.g. 22/tcp.
+	port string
+}
+
+// probeWindow counts the distinct ports probed on each target, and the
+// distinct targets probed on each port, over a sliding window of time.
+type probeWindow struct {
+	size     time.Duration
+	probes   []probe
+	byTarget map[string]map[string]int
+	byPort   map[string]map[string]int
+}
+
+func newProbeWindow(size time.Duration) *probeWindow {
+	return &probeWindow{
+		size:     size,
+		byTarget: make(map[string]map[string]int),
+		byPort:   make(map[string]map[string]int),
+	}
+}
+
+// Add adds the given probe, and forgets those older than the window.
+func (w *probeWindow) Add(p probe) {
+	cut := p.at.Add(-w.size)
+	i := 0
+	for ; i < len(w.probes) && !w.probes[i].at.After(cut); i++ {
+		old := w.probes[i]
+		decrement(w.byTarget, old.target, old.port)
+		decrement(w.byPort, old.port, old.target)
+	}
+	w.probes = append(w.probes[i:], p)
+	increment(w.byTarget, p.target, p.port)
+	increment(w.byPort, p.port, p.target)
+}
+
+// Ports returns the number of distinct ports probed on the given target.
+func (w *probeWindow) Ports(target string) int {
+	return len(w.byTarget[target])
+}
+
+// Targets returns the number of distinct targets probed on the given port.
+func (w *probeWindow) Targets(port string) int {
+	return len(w.byPort[port])
+}
+
+func increment(m map[string]map[string]int, k1, k2 string) {
+	if m[k1] == nil {
+		m[k1] = make(map[string]int)
+	}
+	m[k1][k2]++
+}
+
+func decrement(m map[string]map[string]int, k1, k2 string) {
+	m[k1][k2]--
+	if m[k1][k2] <= 0 {
+		delete(m[k1], k2)
+	}
+	if len(m[k1]) == 0 {
+		delete(m, k1)
+	}
+}
+
+// recordUDPReply records the given packet as traffic from its source port to
+// its destination host, if it is UDP, so that the host's own packets to that
+// port aren't taken for probes.
+func recordUDPReply(hosts map[MAC]*Host, vp ViewPair, now time.Time) {
+	src := vp.srcIP()
+	if vp.UDP == nil || src == nil || vp.Dst.MAC == nil {
+		return
+	}
+	h, ok := hosts[*vp.Dst.MAC]
+	if !ok {
+		return
+	}
+	key := fmt.Sprintf("%s:%d", src, vp.UDP.SrcPort)
+	if _, ok := h.udpReplies[key]; !ok && len(h.udpReplies) >= maxUDPReplies {
+		for k, at := range h.udpReplies {
+			if now.Sub(at) > udpReplyTTL {
+				delete(h.udpReplies, k)
+			}
+		}
+		if len(h.udpReplies) >= maxUDPReplies {
+			return
+		}
+	}
+	if h.udpReplies == nil {
+		h.udpReplies = make(map[string]time.Time)
+	}
+	h.udpReplies[key] = now
+}
+
+// repliedUDP returns whether the given UDP port of the given target has
+// recently sent traffic to the given host.
+func repliedUDP(h *Host, target net.IP, port int, now time.Time) bool {
+	at, ok := h.udpReplies[fmt.Sprintf("%s:%d", target, port)]
+	return ok && now.Sub(at) <= udpReplyTTL
+}
+
+// newProbe returns the probe sent by the given host in the given packet, if it
+// could be part of a port scan, along with the type of probe.
+func newProbe(h *Host, vp ViewPair, now time.Time) (probe, string, bool) {
+	dst := vp.Dst.IPv4
+	if dst == nil {
+		dst = vp.Dst.IPv6
+	}
+	if dst == nil || dst.IsMulticast() || dst.Equal(net.IPv4bcast) {
+		return probe{}, "", false
+	}
+	var typ, port string
+	switch {
+	case vp.TCP != nil:
+		tcp := vp.TCP
+		switch {
+		case tcp.SYN && !tcp.ACK:
+			typ = PortScanSYN
+		case tcp.FIN && tcp.PSH && tcp.URG && !tcp.ACK:
+			typ = PortScanXmas
+		case tcp.FIN && !tcp.ACK && !tcp.SYN && !tcp.RST:
+			typ = PortScanFIN
+		case !tcp.FIN && !tcp.SYN && !tcp.RST && !tcp.PSH &&
+			!tcp.ACK && !tcp.URG && !tcp.ECE && !tcp.CWR && !tcp.NS:
+			typ = PortScanNULL
+		default:
+			return probe{}, "", false
+		}
+		port = fmt.Sprintf("%d/tcp", tcp.DstPort)
+	case vp.UDP != nil:
+		udp := vp.UDP
+		// Replies from a server to many ephemeral ports of one client
+		// aren't probes.
+		if srcLooksLikeServer(int(udp.SrcPort), int(udp.DstPort)) {
+			return probe{}, "", false
+		}
+		// Nor is traffic to a port that has been talking to the host.
+		if repliedUDP(h, dst, int(udp.DstPort), now) {
+			return probe{}, "", false
+		}
+		typ = PortScanUDP
+		port = fmt.Sprintf("%d/udp", udp.DstPort)
+	default:
+		return probe{}, "", false
+	}
+	return probe{at: now, target: dst.String(), port: port}, typ, true
+}
+
+// updatePortScan counts the probe sent by the given host in the given packet,
+// if any, and raises host.port-scan.start once it exceeds the configured
+// thresholds. Once the host stops, host.port-scan.stop is raised.
+func (w *Watcher) updatePortScan(
+	hosts map[MAC]*Host,
+	h *Host,
+	vp ViewPair,
+	now time.Time,
+) {
+	recordUDPReply(hosts, vp, now)
+	p, typ, ok := newProbe(h, vp, now)
+	if !ok {
+		return
+	}
+	c := w.conf.PortScan.withDefaults()
+	if h.probes == nil {
+		h.probes = newProbeWindow(c.Window.Duration)
+	}
+	h.probes.Add(p)
+	ports, targets := h.probes.Ports(p.target), h.probes.Targets(p.port)
+	if ports < c.Ports && targets < c.Targets {
+		return
+	}
+	// The scan is read by the expiry callback, outside of this goroutine.
+	w.mu.Lock()
+	if h.ActivityPortScan == nil {
+		h.ActivityPortScan = newActivity(w.clock, c.TTL.Duration, func(a *Activity) {
+			w.mu.Lock()
+			scan := *h.PortScan
+			up := a.Up()
+			w.mu.Unlock()
+			w.emit(Event{
+				Type: HostPortScanStop,
+				Body: EventHostPortScanStop{
+					Host: h,
+					Scan: scan,
+					Up:   up,
+				},
+			})
+		})
+	}
+	if !h.ActivityPortScan.IsActive || h.PortScan == nil {
+		h.PortScan = &PortScan{
+			Type:  typ,
+			Sweep: ports < c.Ports,
+		}
+	}
+	if ports > h.PortScan.Ports {
+		h.PortScan.Ports = ports
+	}
+	if targets > h.PortScan.Targets {
+		h.PortScan.Targets = targets
+	}
+	scan := *h.PortScan
+	started := !h.ActivityPortScan.Touch(now)
+	w.mu.Unlock()
+	if started {
+		w.emit(Event{
+			Type: HostPortScanStart,
+			Body: EventHostPortScanStart{h, scan},
+		})
+	}
+}
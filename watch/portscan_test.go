@@ -0,0 +1,223 @@
+package watch
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	scannerMAC = MAC("00:00:5e:00:53:01")
+	targetMAC  = MAC("00:00:5e:00:53:02")
+)
+
+// scanPacket returns a packet between the scanner at 192.0.2.1 and the given
+// target, which is from the target if reply is given.
+func scanPacket(target net.IP, tcp *layers.TCP, udp *layers.UDP, reply bool) ViewPair {
+	src, dst := scannerMAC, targetMAC
+	vp := ViewPair{
+		Src: View{MAC: &src, IPv4: net.IP{192, 0, 2, 1}},
+		Dst: View{MAC: &dst, IPv4: target},
+		TCP: tcp,
+		UDP: udp,
+	}
+	if reply {
+		vp.Src, vp.Dst = vp.Dst, vp.Src
+		vp.IPv4 = &layers.IPv4{SrcIP: target, DstIP: vp.Dst.IPv4}
+	}
+	return vp
+}
+
+func TestNewProbe(t *testing.T) {
+	target := net.IP{192, 0, 2, 2}
+	now := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	tests := []struct {
+		name string
+		tcp  *layers.TCP
+		udp  *layers.UDP
+		typ  string
+		port string
+	}{
+		{name: "syn", tcp: &layers.TCP{DstPort: 22, SYN: true}, typ: PortScanSYN, port: "22/tcp"},
+		{name: "syn-ack", tcp: &layers.TCP{DstPort: 22, SYN: true, ACK: true}},
+		{name: "fin", tcp: &layers.TCP{DstPort: 22, FIN: true}, typ: PortScanFIN, port: "22/tcp"},
+		{name: "fin-ack", tcp: &layers.TCP{DstPort: 22, FIN: true, ACK: true}},
+		{name: "null", tcp: &layers.TCP{DstPort: 22}, typ: PortScanNULL, port: "22/tcp"},
+		{
+			name: "xmas",
+			tcp:  &layers.TCP{DstPort: 22, FIN: true, PSH: true, URG: true},
+			typ:  PortScanXmas,
+			port: "22/tcp",
+		},
+		{name: "rst", tcp: &layers.TCP{DstPort: 22, RST: true}},
+		{name: "ack", tcp: &layers.TCP{DstPort: 22, ACK: true}},
+		{
+			name: "udp",
+			udp:  &layers.UDP{SrcPort: 50000, DstPort: 161},
+			typ:  PortScanUDP,
+			port: "161/udp",
+		},
+		{name: "udp from a server", udp: &layers.UDP{SrcPort: 53, DstPort: 50000}},
+		{name: "udp to a port that replied", udp: &layers.UDP{SrcPort: 50000, DstPort: 53}},
+	}
+	h := &Host{MAC: scannerMAC}
+	h.udpReplies = map[string]time.Time{"192.0.2.2:53": now.Add(-time.Minute)}
+	for _, tt := range tests {
+		p, typ, ok := newProbe(h, scanPacket(target, tt.tcp, tt.udp, false), now)
+		if ok != (tt.typ != "") || typ != tt.typ || p.port != tt.port {
+			t.Errorf("%s: probe of %q (%q, %v), want %q (%q)", tt.name, p.port, typ, ok, tt.port, tt.typ)
+			continue
+		}
+		if ok && p.target != target.String() {
+			t.Errorf("%s: probe of %s, want %s", tt.name, p.target, target)
+		}
+	}
+
+	// Replies are only remembered for so long.
+	h.udpReplies["192.0.2.2:53"] = now.Add(-udpReplyTTL - time.Second)
+	udp := &layers.UDP{SrcPort: 50000, DstPort: 53}
+	if _, _, ok := newProbe(h, scanPacket(target, nil, udp, false), now); !ok {
+		t.Errorf("udp to a port that replied long ago isn't a probe")
+	}
+}
+
+// scanStep is a packet sent at the given time since the start of a test scan.
+type scanStep struct {
+	at time.Duration
+	vp ViewPair
+}
+
+// syn returns a step probing the given port of 192.0.2.x.
+func syn(at time.Duration, x byte, port int) scanStep {
+	tcp := &layers.TCP{DstPort: layers.TCPPort(port), SYN: true}
+	return scanStep{at, scanPacket(net.IP{192, 0, 2, x}, tcp, nil, false)}
+}
+
+// udpTo returns a step of UDP traffic to the given port of 192.0.2.x, or from
+// it if reply is given.
+func udpTo(at time.Duration, x byte, port int, reply bool) scanStep {
+	udp := &layers.UDP{SrcPort: 50000, DstPort: layers.UDPPort(port)}
+	if reply {
+		udp.SrcPort, udp.DstPort = udp.DstPort, udp.SrcPort
+	}
+	return scanStep{at, scanPacket(net.IP{192, 0, 2, x}, nil, udp, reply)}
+}
+
+// portScanEvents returns the port scan events raised by the given packets.
+func portScanEvents(c PortScanConfig, steps []scanStep) []Event {
+	log := logrus.New()
+	log.Out = ioutil.Discard
+	w := NewWatcher(log)
+	w.conf = &Config{PortScan: c}
+	w.clock = newReplayClock()
+	w.events = make(chan Event, len(steps)+1)
+	hosts := map[MAC]*Host{
+		scannerMAC: {MAC: scannerMAC},
+		targetMAC:  {MAC: targetMAC},
+	}
+	t0 := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	for _, s := range steps {
+		now := t0.Add(s.at)
+		w.clock.advance(now)
+		w.updatePortScan(hosts, hosts[*s.vp.Src.MAC], s.vp, now)
+	}
+	close(w.events)
+	var events []Event
+	for e := range w.events {
+		events = append(events, e)
+	}
+	return events
+}
+
+func TestUpdatePortScan(t *testing.T) {
+	c := PortScanConfig{
+		Window:  Duration{10 * time.Second},
+		Ports:   3,
+		Targets: 3,
+		TTL:     Duration{time.Minute},
+	}
+	tests := []struct {
+		name  string
+		steps []scanStep
+		// want is the scan started, if any, and stop whether it then
+		// stopped.
+		want *PortScan
+		stop bool
+	}{
+		{
+			name:  "too few ports",
+			steps: []scanStep{syn(0, 2, 22), syn(0, 2, 80), syn(0, 2, 22)},
+		},
+		{
+			name:  "vertical",
+			steps: []scanStep{syn(0, 2, 22), syn(0, 2, 80), syn(time.Second, 2, 443)},
+			want:  &PortScan{Type: PortScanSYN, Ports: 3, Targets: 1},
+		},
+		{
+			name:  "horizontal",
+			steps: []scanStep{syn(0, 2, 22), syn(0, 3, 22), syn(time.Second, 4, 22)},
+			want:  &PortScan{Type: PortScanSYN, Sweep: true, Ports: 1, Targets: 3},
+		},
+		{
+			name:  "slower than the window",
+			steps: []scanStep{syn(0, 2, 22), syn(6*time.Second, 2, 80), syn(12*time.Second, 2, 443)},
+		},
+		{
+			name: "stops once quiet",
+			steps: []scanStep{
+				syn(0, 2, 22), syn(0, 2, 80), syn(0, 2, 443),
+				syn(0, 2, 8080),
+				syn(2*time.Minute, 3, 22),
+			},
+			want: &PortScan{Type: PortScanSYN, Ports: 4, Targets: 1},
+			stop: true,
+		},
+		{
+			name:  "udp",
+			steps: []scanStep{udpTo(0, 2, 53, false), udpTo(0, 2, 123, false), udpTo(0, 2, 161, false)},
+			want:  &PortScan{Type: PortScanUDP, Ports: 3, Targets: 1},
+		},
+		{
+			name: "udp to ports that replied",
+			steps: []scanStep{
+				udpTo(0, 2, 53, true), udpTo(0, 2, 123, true),
+				udpTo(0, 2, 53, false), udpTo(0, 2, 123, false), udpTo(0, 2, 161, false),
+			},
+		},
+	}
+	for _, tt := range tests {
+		events := portScanEvents(c, tt.steps)
+		var started *PortScan
+		stopped := false
+		for _, e := range events {
+			switch b := e.Body.(type) {
+			case EventHostPortScanStart:
+				if started != nil {
+					t.Errorf("%s: started twice", tt.name)
+				}
+				scan := b.Scan
+				started = &scan
+			case EventHostPortScanStop:
+				stopped = true
+				if started != nil && b.Scan != *tt.want {
+					t.Errorf("%s: stopped %+v, want %+v", tt.name, b.Scan, *tt.want)
+				}
+			}
+		}
+		switch {
+		case (started == nil) != (tt.want == nil):
+			t.Errorf("%s: started %+v, want %+v", tt.name, started, tt.want)
+		case started != nil && started.Type != tt.want.Type:
+			t.Errorf("%s: started a %s scan, want %s", tt.name, started.Type, tt.want.Type)
+		case started != nil && started.Sweep != tt.want.Sweep:
+			t.Errorf("%s: started a scan with sweep %v, want %v", tt.name, started.Sweep, tt.want.Sweep)
+		}
+		if stopped != tt.stop {
+			t.Errorf("%s: stopped %v, want %v", tt.name, stopped, tt.stop)
+		}
+	}
+}
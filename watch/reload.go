@@ -0,0 +1,107 @@
+package watch
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+)
+
+// reloadDelay is how long to wait for changes to a config file to settle
+// before reloading it, since editors often write a file in several steps.
+const reloadDelay = 250 * time.Millisecond
+
+// watchConfig calls reload whenever any of the given sources of the config at
+// the given path change, or the process receives SIGHUP, until the given
+// context is done. Sources are files, or patterns of files, as returned by
+// each successful reload.
+func watchConfig(
+	ctx context.Context,
+	log *logrus.Logger,
+	path string,
+	sources []string,
+	reload func() []string,
+) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	// Directories are watched rather than the files themselves, since
+	// editors commonly save by renaming a new file over the old one, which
+	// would otherwise end the watch. It's also how new files matching an
+	// include pattern are seen.
+	var changes <-chan fsnotify.Event
+	var errs <-chan error
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.WithError(err).Warnf("failed to watch config %s, reload with SIGHUP", path)
+	} else {
+		defer fw.Close()
+		changes, errs = fw.Events, fw.Errors
+	}
+	watch := func(sources []string) {
+		if fw == nil {
+			return
+		}
+		for _, src := range sources {
+			if err := fw.Add(filepath.Dir(src)); err != nil {
+				log.WithError(err).Warnf("failed to watch config %s, reload with SIGHUP", src)
+			}
+		}
+	}
+	watch(sources)
+	doReload := func() {
+		if next := reload(); next != nil {
+			sources = next
+			watch(sources)
+		}
+	}
+
+	var settle <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			log.Infof("received SIGHUP, reloading config %s", path)
+			doReload()
+		case ev := <-changes:
+			if !matchSource(sources, ev.Name) {
+				continue
+			}
+			if ev.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+				continue
+			}
+			settle = time.After(reloadDelay)
+		case <-settle:
+			settle = nil
+			log.Infof("config %s changed, reloading", path)
+			doReload()
+		case err := <-errs:
+			log.WithError(err).Warnf("failed watching config %s", path)
+		}
+	}
+}
+
+// matchSource returns whether the given file is one of the given sources, or
+// matches one of their patterns.
+func matchSource(sources []string, name string) bool {
+	name, err := filepath.Abs(name)
+	if err != nil {
+		return false
+	}
+	for _, src := range sources {
+		if src == name {
+			return true
+		}
+		if ok, _ := filepath.Match(src, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package watch
+
+import (
+	"net"
+	"reflect"
+)
+
+// ConfigSchema returns a JSON Schema of config files, which editors can use to
+// check and complete them, whether they're toml, yaml or json. Conflicting
+// options are only found by LoadConfig.
+func ConfigSchema() map[string]interface{} {
+	s := typeSchema(reflect.TypeOf(Config{}))
+	s["properties"].(map[string]interface{})["$schema"] = map[string]interface{}{
+		"type": "string",
+	}
+	s["$schema"] = "http://json-schema.org/draft-07/schema#"
+	s["title"] = "netwatch config"
+	return s
+}
+
+// durationPattern matches the durations that time.ParseDuration accepts.
+const durationPattern = `^[-+]?([0-9]*(\.[0-9]*)?(ns|us|µs|ms|s|m|h))+$|^0$`
+
+// fieldSchemas are the schemas of fields that aren't simply those of their
+// types, such as strings with only some valid values, by their struct and key.
+var fieldSchemas = map[reflect.Type]map[string]map[string]interface{}{
+	// Profiles are overlays of the whole config.
+	reflect.TypeOf(Config{}): {
+		"profiles": {
+			"type":                 "object",
+			"additionalProperties": map[string]interface{}{"$ref": "#"},
+		},
+	},
+	reflect.TypeOf(TriggerSpec{}): {
+		"doBuiltin": {"type": "string", "enum": builtins},
+	},
+	reflect.TypeOf(EnrichStep{}): {
+		"builtin": {"type": "string", "enum": enrichBuiltins},
+	},
+}
+
+// typeSchema returns a JSON Schema of the values of the given type, as
+// decoded from config files.
+func typeSchema(t reflect.Type) map[string]interface{} {
+	switch t {
+	case reflect.TypeOf(EventType(0)):
+		var names []string
+		for ty := HostTouch; ty <= Digest; ty++ {
+			names = append(names, eventName(ty))
+		}
+		return map[string]interface{}{"type": "string", "enum": names}
+	case reflect.TypeOf(Duration{}):
+		return map[string]interface{}{"type": "string", "pattern": durationPattern}
+	case reflect.TypeOf(Rate{}):
+		return map[string]interface{}{
+			"type":    "string",
+			"pattern": `^\s*[0-9]+\s*/\s*([0-9.]*(ns|us|µs|ms|s|m|h))+\s*$`,
+		}
+	case reflect.TypeOf(CIDR{}):
+		return map[string]interface{}{"type": "string", "pattern": `/[0-9]+$`}
+	case reflect.TypeOf(net.IP{}), reflect.TypeOf(MAC("")):
+		return map[string]interface{}{"type": "string"}
+	}
+	if reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		return map[string]interface{}{"type": "string"}
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		props := make(map[string]interface{})
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			key := fieldKey(f)
+			if s, ok := fieldSchemas[t][key]; ok {
+				props[key] = s
+				continue
+			}
+			props[key] = typeSchema(f.Type)
+		}
+		return map[string]interface{}{
+			"type":                 "object",
+			"properties":           props,
+			"additionalProperties": false,
+		}
+	case reflect.Map:
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": typeSchema(t.Elem()),
+		}
+	case reflect.Slice:
+		return map[string]interface{}{
+			"type":  "array",
+			"items": typeSchema(t.Elem()),
+		}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	}
+	return map[string]interface{}{}
+}
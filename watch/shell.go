@@ -0,0 +1,405 @@
+package watch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultShellTimeout is how long shell commands may run for, when a trigger
+// has no timeout.
+const defaultShellTimeout = 30 * time.Second
+
+// defaultOnShellTimeout is how long onShell commands may run for, when a
+// trigger has no timeout. They're run as each event is published, holding up
+// every other trigger, so must be quick.
+const defaultOnShellTimeout = 2 * time.Second
+
+// shellQueueSize is how many events may wait for a trigger's workers to run,
+// after which further events are dropped.
+const shellQueueSize = 64
+
+// shellFuncs are the functions available to shell templates, for quoting
+// event fields safely, e.g. echo {{quote .Description}}.
+var shellFuncs = template.FuncMap{
+	"quote": shellQuote,
+}
+
+// shellQuote returns the given value quoted as a single shell word.
+func shellQuote(v interface{}) string {
+	return "'" + strings.Replace(fmt.Sprint(v), "'", `'"'"'`, -1) + "'"
+}
+
+// newShellTemplate parses the given onShell or doShell template, after
+// expanding any environment variables in it, other than the NETWATCH_* ones
+// that are only set when it's run.
+func newShellTemplate(text string) (*template.Template, error) {
+	text = os.Expand(text, func(name string) string {
+		if strings.HasPrefix(name, "NETWATCH_") {
+			return "${" + name + "}"
+		}
+		return os.Getenv(name)
+	})
+	return template.New("").Funcs(shellFuncs).Parse(text)
+}
+
+// shellEvent is an Event as given to shell commands, as JSON on stdin and as
+// NETWATCH_* environment variables.
+type shellEvent struct {
+	Trigger     string     `json:"trigger"`
+	Type        string     `json:"type"`
+	Severity    string     `json:"severity"`
+	Description string     `json:"description"`
+	Time        time.Time  `json:"time"`
+	Host        *shellHost `json:"host,omitempty"`
+	Port        *shellPort `json:"port,omitempty"`
+	HTTP        *shellHTTP `json:"http,omitempty"`
+	// Enrich is what the trigger's enrich steps have added so far.
+	Enrich map[string]interface{} `json:"enrich,omitempty"`
+	// Durations are in seconds.
+	Up   float64 `json:"up,omitempty"`
+	Down float64 `json:"down,omitempty"`
+	Age  float64 `json:"age,omitempty"`
+}
+
+type shellHost struct {
+	MAC      string `json:"mac"`
+	Vendor   string `json:"vendor,omitempty"`
+	Hostname string `json:"hostname,omitempty"`
+	OS       string `json:"os,omitempty"`
+	IPv4     net.IP `json:"ipv4,omitempty"`
+	IPv6     net.IP `json:"ipv6,omitempty"`
+}
+
+type shellPort struct {
+	Num   int    `json:"num"`
+	Proto string `json:"proto"`
+}
+
+type shellHTTP struct {
+	Method    string `json:"method"`
+	Host      string `json:"host"`
+	Path      string `json:"path"`
+	UserAgent string `json:"userAgent,omitempty"`
+	Status    int    `json:"status,omitempty"`
+}
+
+func newShellEvent(trigger string, e Event, info printableEvent) shellEvent {
+	se := shellEvent{
+		Trigger:     trigger,
+		Type:        eventName(e.Type),
+		Severity:    info.Severity,
+		Description: info.Description,
+		Time:        eventTime(e),
+		Up:          info.Up.Seconds(),
+		Down:        info.Down.Seconds(),
+		Age:         info.Age.Seconds(),
+		Enrich:      e.Enrich,
+	}
+	if h := info.Host; h.MAC != "" {
+		se.Host = &shellHost{
+			MAC:      string(h.MAC),
+			Vendor:   h.Vendor,
+			Hostname: h.Hostname,
+			OS:       h.OS,
+			IPv4:     h.IPv4,
+			IPv6:     h.IPv6,
+		}
+	}
+	if p := info.Port; p.Num != 0 {
+		se.Port = &shellPort{Num: p.Num, Proto: portProto(p)}
+	}
+	if tx := info.HTTP; tx.Method != "" {
+		se.HTTP = &shellHTTP{
+			Method:    tx.Method,
+			Host:      tx.Host,
+			Path:      tx.Path,
+			UserAgent: tx.UserAgent,
+			Status:    tx.Status,
+		}
+	}
+	return se
+}
+
+// env returns the NETWATCH_* environment variables of this event, for those
+// fields that are set.
+func (se shellEvent) env() []string {
+	vars := [][2]string{
+		{"TRIGGER", se.Trigger},
+		{"TYPE", se.Type},
+		{"SEVERITY", se.Severity},
+		{"DESCRIPTION", se.Description},
+		{"TIME", se.Time.Format(time.RFC3339)},
+	}
+	if h := se.Host; h != nil {
+		vars = append(vars,
+			[2]string{"HOST_MAC", h.MAC},
+			[2]string{"HOST_VENDOR", h.Vendor},
+			[2]string{"HOST_HOSTNAME", h.Hostname},
+			[2]string{"HOST_OS", h.OS},
+			[2]string{"HOST_IPV4", ipString(h.IPv4)},
+			[2]string{"HOST_IPV6", ipString(h.IPv6)},
+		)
+	}
+	if p := se.Port; p != nil {
+		vars = append(vars,
+			[2]string{"PORT", fmt.Sprintf("%d/%s", p.Num, p.Proto)},
+			[2]string{"PORT_NUM", strconv.Itoa(p.Num)},
+			[2]string{"PORT_PROTO", p.Proto},
+		)
+	}
+	if tx := se.HTTP; tx != nil {
+		vars = append(vars,
+			[2]string{"HTTP_METHOD", tx.Method},
+			[2]string{"HTTP_HOST", tx.Host},
+			[2]string{"HTTP_PATH", tx.Path},
+			[2]string{"HTTP_STATUS", strconv.Itoa(tx.Status)},
+		)
+	}
+	for _, k := range sortedKeys(se.Enrich) {
+		switch v := se.Enrich[k].(type) {
+		case string, float64, bool:
+			vars = append(vars, [2]string{"ENRICH_" + envName(k), fmt.Sprint(v)})
+		}
+	}
+	var env []string
+	for _, v := range vars {
+		if v[1] != "" {
+			env = append(env, "NETWATCH_"+v[0]+"="+v[1])
+		}
+	}
+	return env
+}
+
+// envName returns the given key as part of an environment variable name,
+// e.g. owner-name as OWNER_NAME.
+func envName(key string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, key)
+}
+
+func ipString(ip net.IP) string {
+	if len(ip) == 0 {
+		return ""
+	}
+	return ip.String()
+}
+
+// portProto returns the protocol of the given port, or nothing if it's unset.
+func portProto(p Port) string {
+	if p.Num == 0 {
+		return ""
+	}
+	if p.isTCP {
+		return "tcp"
+	}
+	return "udp"
+}
+
+// shellCommand is a parsed onShell or doShell template of a trigger.
+type shellCommand struct {
+	trigger string
+	tmpl    *template.Template
+	timeout time.Duration
+}
+
+func newShellCommand(trigger string, text string, spec TriggerSpec) (*shellCommand, error) {
+	tmpl, err := newShellTemplate(text)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to template parse shell: %s", text)
+	}
+	timeout := spec.Timeout.Duration
+	if timeout == 0 {
+		timeout = defaultShellTimeout
+	}
+	return &shellCommand{trigger: trigger, tmpl: tmpl, timeout: timeout}, nil
+}
+
+// render returns the script that this command runs for the given event.
+func (c *shellCommand) render(info printableEvent) (string, error) {
+	var buf bytes.Buffer
+	if err := c.tmpl.Execute(&buf, info); err != nil {
+		return "", errors.Wrap(err, "failed to execute template")
+	}
+	return buf.String(), nil
+}
+
+// run runs this command for the given event, returning its combined output.
+func (c *shellCommand) run(e Event) ([]byte, error) {
+	var out bytes.Buffer
+	err := c.exec(e, &out, &out)
+	return out.Bytes(), err
+}
+
+// output runs this command for the given event, returning its output, and
+// any errors along with what it wrote to stderr.
+func (c *shellCommand) output(e Event) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	if err := c.exec(e, &stdout, &stderr); err != nil {
+		return nil, errors.Wrapf(err, "%s", strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+func (c *shellCommand) exec(e Event, stdout, stderr io.Writer) error {
+	info := newEventInfo(e)
+	script, err := c.render(info)
+	if err != nil {
+		return err
+	}
+	se := newShellEvent(c.trigger, e, info)
+	stdin, err := json.Marshal(se)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command("/bin/sh", "-c", script)
+	cmd.Stdin = bytes.NewReader(append(stdin, '\n'))
+	cmd.Env = append(os.Environ(), se.env()...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	// The command is run in its own process group, so that anything it
+	// starts is also killed if it times out.
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	timeout := time.NewTimer(c.timeout)
+	defer timeout.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timeout.C:
+		killProcessGroup(cmd)
+		<-done
+		return errors.Errorf("command timed out after %s", c.timeout)
+	}
+}
+
+// newSubFromShell returns a Subscriber that runs the enrich steps and doShell
+// command of the given trigger for each event. These are run by a pool of the
+// trigger's workers, so as not to block other Subscribers, until the given
+// context is done. Events are dropped if too many are waiting.
+func newSubFromShell(
+	ctx context.Context,
+	log *logrus.Logger,
+	name string,
+	spec TriggerSpec,
+) (Subscriber, error) {
+	c, err := newShellCommand(name, spec.DoShell, spec)
+	if err != nil {
+		return nil, err
+	}
+	en, err := newEnricher(log, name, spec)
+	if err != nil {
+		return nil, err
+	}
+	run := func(e Event) {
+		if en != nil {
+			e = en.Enrich(e)
+		}
+		b, err := c.run(e)
+		if err != nil {
+			log.WithError(err).Errorf("failed to run command of trigger %s: %s", name, b)
+			return
+		}
+		out := strings.TrimSpace(string(b))
+		fmt.Println(out)
+	}
+	return newWorkerPool(ctx, spec.Workers, run), nil
+}
+
+// newWorkerPool returns a Subscriber that queues events for the given number
+// of workers, at least one, to each run. They run until the given context is
+// done, when they finish what's already queued. Events are dropped if too many
+// are waiting.
+func newWorkerPool(ctx context.Context, workers int, run func(e Event)) Subscriber {
+	jobs := make(chan Event, shellQueueSize)
+	if workers == 0 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for {
+				select {
+				case e := <-jobs:
+					run(e)
+				case <-ctx.Done():
+					// Finish what's already queued.
+					for {
+						select {
+						case e := <-jobs:
+							run(e)
+						default:
+							return
+						}
+					}
+				}
+			}
+		}()
+	}
+	return func(e Event) error {
+		select {
+		case jobs <- e:
+			return nil
+		default:
+			return errors.Errorf(
+				"dropped %s event, %d already waiting",
+				eventName(e.Type),
+				shellQueueSize,
+			)
+		}
+	}
+}
+
+// newShouldDoFromShell returns a filter that runs the onShell command of the
+// given trigger for each event, which should exit 0 for those to trigger. It
+// runs synchronously, blocking other Subscribers until it's done.
+func newShouldDoFromShell(
+	log *logrus.Logger,
+	name string,
+	spec TriggerSpec,
+) (func(e Event) bool, error) {
+	c, err := newShellCommand(name, spec.OnShell, spec)
+	if err != nil {
+		return nil, err
+	}
+	if spec.Timeout.Duration == 0 {
+		c.timeout = defaultOnShellTimeout
+	}
+	return func(e Event) bool {
+		b, err := c.run(e)
+		if err != nil {
+			// The point of this shell command is to return a
+			// non-zero exit code when an event should be skipped.
+			// However, we also log so as to not preclude
+			// debugging.
+			log.Debugf("failed to run output: %v: %s", err, string(b))
+			return false
+		}
+		return true
+	}, nil
+}
@@ -0,0 +1,222 @@
+package watch
+
+import (
+	"sort"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/reassembly"
+)
+
+var (
+	// Connections without any new data for this long are flushed and
+	// closed, even if no FIN or RST was seen.
+	ttlStream = 2 * time.Minute
+	// How often, in packet time, to look for connections to flush.
+	streamFlushInterval = 10 * time.Second
+	// Upper limits on the number of pages, of about 2KiB each, that the
+	// assembler buffers while waiting for out-of-order packets.
+	streamMaxPages        = 8192
+	streamMaxPagesPerConn = 64
+)
+
+// StreamConn identifies a single TCP connection.
+type StreamConn struct {
+	Net       gopacket.Flow
+	Transport gopacket.Flow
+}
+
+// StreamChunk is a piece of in-order data from one direction of a TCP
+// connection.
+type StreamChunk struct {
+	Dir reassembly.TCPFlowDirection
+	// Src is the MAC address that sent the data.
+	Src MAC
+	// Data is only valid for the duration of a call to Feed.
+	Data []byte
+	// Gap is whether some data was lost just before Data.
+	Gap       bool
+	Timestamp time.Time
+}
+
+// StreamParser incrementally parses application data from a single TCP
+// connection. Parsers are fed synchronously as packets are scanned, so that
+// reading from an offline pcap or a live interface gives the same results.
+type StreamParser interface {
+	// Feed is given the next in-order chunk of data. It returns false once
+	// the parser is no longer interested in the connection, after which it
+	// is not fed again.
+	Feed(c StreamChunk) bool
+	// Close is called once no more data will be fed, whether because the
+	// connection ended or timed out.
+	Close()
+}
+
+// StreamParserFactory returns a new StreamParser for the given connection, or
+// nil if it isn't interested. Results are given to report, along with the MAC
+// address of the Host they should be attributed to.
+type StreamParserFactory func(
+	conn StreamConn,
+	report func(mac MAC, result interface{}),
+) StreamParser
+
+// RegisterStreamParser adds a named StreamParserFactory to be used for each
+// new TCP connection. Registering under an existing name replaces it. This
+// must be called before watching.
+func (w *Watcher) RegisterStreamParser(name string, f StreamParserFactory) {
+	w.streamParsers[name] = f
+}
+
+func defaultStreamParsers() map[string]StreamParserFactory {
+	return map[string]StreamParserFactory{
+		"http": newHTTPParser,
+	}
+}
+
+// streamContext is passed along with every TCP packet given to the
+// reassembly Assembler, so that streams know which MAC address sent the
+// data they receive.
+type streamContext struct {
+	ci  gopacket.CaptureInfo
+	src MAC
+}
+
+// GetCaptureInfo satisfies the reassembly.AssemblerContext interface.
+func (c *streamContext) GetCaptureInfo() gopacket.CaptureInfo {
+	return c.ci
+}
+
+// streamFactory creates a new tcpStream for each TCP connection seen by the
+// reassembly Assembler.
+type streamFactory struct {
+	parsers map[string]StreamParserFactory
+	report  func(mac MAC, result interface{})
+}
+
+// New satisfies the reassembly.StreamFactory interface.
+func (f *streamFactory) New(
+	netFlow, tcpFlow gopacket.Flow,
+	tcp *layers.TCP,
+	ac reassembly.AssemblerContext,
+) reassembly.Stream {
+	conn := StreamConn{Net: netFlow, Transport: tcpFlow}
+	// Iterate in a stable order, so that results are reported in the same
+	// order between runs.
+	var names []string
+	for name := range f.parsers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	s := new(tcpStream)
+	for _, name := range names {
+		if p := f.parsers[name](conn, f.report); p != nil {
+			s.parsers = append(s.parsers, p)
+		}
+	}
+	return s
+}
+
+// tcpStream receives in-order data for both directions of one TCP
+// connection, and hands it to each interested StreamParser.
+type tcpStream struct {
+	parsers []StreamParser
+}
+
+// Accept satisfies the reassembly.Stream interface. Streams are started even
+// when their SYN was never seen, since we are often sniffing connections that
+// were established before we started.
+func (s *tcpStream) Accept(
+	tcp *layers.TCP,
+	ci gopacket.CaptureInfo,
+	dir reassembly.TCPFlowDirection,
+	nextSeq reassembly.Sequence,
+	start *bool,
+	ac reassembly.AssemblerContext,
+) bool {
+	*start = true
+	return true
+}
+
+// ReassembledSG satisfies the reassembly.Stream interface.
+func (s *tcpStream) ReassembledSG(
+	sg reassembly.ScatterGather,
+	ac reassembly.AssemblerContext,
+) {
+	dir, _, _, skip := sg.Info()
+	n, _ := sg.Lengths()
+	if len(s.parsers) == 0 || (n == 0 && skip == 0) {
+		return
+	}
+	c := StreamChunk{
+		Dir:       dir,
+		Data:      sg.Fetch(n),
+		Gap:       skip != 0,
+		Timestamp: ac.GetCaptureInfo().Timestamp,
+	}
+	if ctx, ok := ac.(*streamContext); ok {
+		c.Src = ctx.src
+	}
+	active := s.parsers[:0]
+	for _, p := range s.parsers {
+		if p.Feed(c) {
+			active = append(active, p)
+		} else {
+			p.Close()
+		}
+	}
+	s.parsers = active
+}
+
+// ReassemblyComplete satisfies the reassembly.Stream interface.
+func (s *tcpStream) ReassemblyComplete(ac reassembly.AssemblerContext) bool {
+	for _, p := range s.parsers {
+		p.Close()
+	}
+	s.parsers = nil
+	return true
+}
+
+// streamAssembler reassembles TCP connections from scanned packets, with
+// bounded memory, and flushes connections that time out.
+type streamAssembler struct {
+	asm       *reassembly.Assembler
+	ttl       time.Duration
+	lastFlush time.Time
+}
+
+func newStreamAssembler(f *streamFactory, ttl time.Duration) *streamAssembler {
+	asm := reassembly.NewAssembler(reassembly.NewStreamPool(f))
+	asm.MaxBufferedPagesTotal = streamMaxPages
+	asm.MaxBufferedPagesPerConnection = streamMaxPagesPerConn
+	return &streamAssembler{asm: asm, ttl: ttl}
+}
+
+// Assemble feeds the TCP layer of the given packet, if any. Timeouts are
+// measured in packet time rather than wall time, so that offline pcaps are
+// handled the same as live captures.
+func (a *streamAssembler) Assemble(packet gopacket.Packet) {
+	tcp, ok := packet.TransportLayer().(*layers.TCP)
+	if !ok || packet.NetworkLayer() == nil {
+		return
+	}
+	ctx := &streamContext{ci: packet.Metadata().CaptureInfo}
+	if eth, ok := packet.LinkLayer().(*layers.Ethernet); ok {
+		ctx.src = MAC(eth.SrcMAC.String())
+	}
+	a.asm.AssembleWithContext(packet.NetworkLayer().NetworkFlow(), tcp, ctx)
+
+	ts := ctx.ci.Timestamp
+	if a.lastFlush.IsZero() {
+		a.lastFlush = ts
+	}
+	if ts.Sub(a.lastFlush) >= streamFlushInterval {
+		a.asm.FlushCloseOlderThan(ts.Add(-a.ttl))
+		a.lastFlush = ts
+	}
+}
+
+// Close flushes and closes all remaining connections.
+func (a *streamAssembler) Close() {
+	a.asm.FlushAll()
+}
@@ -0,0 +1,232 @@
+package watch
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+var (
+	clientMAC = net.HardwareAddr{0x00, 0x00, 0x5e, 0x00, 0x53, 0x01}
+	serverMAC = net.HardwareAddr{0x00, 0x00, 0x5e, 0x00, 0x53, 0x02}
+)
+
+// tcpSegment is a TCP packet sent from the client, or the server, of a test
+// connection. The client uses port 40000 unless another is given.
+type tcpSegment struct {
+	clientPort layers.TCPPort
+	fromServer bool
+	seq        uint32
+	syn, fin   bool
+	data       string
+	at         time.Duration
+}
+
+// newTCPPacket returns the given segment of a connection from a client at
+// 192.0.2.1 to a server at 192.0.2.2:80, as captured at the given time.
+func newTCPPacket(t *testing.T, s tcpSegment, start time.Time) gopacket.Packet {
+	eth := &layers.Ethernet{
+		SrcMAC:       clientMAC,
+		DstMAC:       serverMAC,
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolTCP,
+		SrcIP:    net.IP{192, 0, 2, 1},
+		DstIP:    net.IP{192, 0, 2, 2},
+	}
+	tcp := &layers.TCP{
+		SrcPort: s.clientPort,
+		DstPort: 80,
+		Seq:     s.seq,
+		SYN:     s.syn,
+		FIN:     s.fin,
+		ACK:     !s.syn || s.fromServer,
+		Window:  65535,
+	}
+	if tcp.SrcPort == 0 {
+		tcp.SrcPort = 40000
+	}
+	if s.fromServer {
+		eth.SrcMAC, eth.DstMAC = eth.DstMAC, eth.SrcMAC
+		ip.SrcIP, ip.DstIP = ip.DstIP, ip.SrcIP
+		tcp.SrcPort, tcp.DstPort = tcp.DstPort, tcp.SrcPort
+	}
+	if err := tcp.SetNetworkLayerForChecksum(ip); err != nil {
+		t.Fatal(err)
+	}
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	err := gopacket.SerializeLayers(buf, opts, eth, ip, tcp, gopacket.Payload(s.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := gopacket.NewPacket(buf.Bytes(), layers.LayerTypeEthernet, gopacket.Default)
+	md := p.Metadata()
+	md.Timestamp = start.Add(s.at)
+	md.CaptureLength = len(buf.Bytes())
+	md.Length = len(buf.Bytes())
+	return p
+}
+
+// recordingParser records the data fed to it in each direction.
+type recordingParser struct {
+	data   map[MAC]string
+	gaps   int
+	closed bool
+}
+
+func (p *recordingParser) Feed(c StreamChunk) bool {
+	if c.Gap {
+		p.gaps++
+	}
+	p.data[c.Src] += string(c.Data)
+	return true
+}
+
+func (p *recordingParser) Close() {
+	p.closed = true
+}
+
+// assembleSegments feeds the given segments through a stream assembler,
+// returning the parser of the first connection and what the http parser
+// reported.
+func assembleSegments(
+	t *testing.T,
+	segments []tcpSegment,
+	ttl time.Duration,
+	close bool,
+) (*recordingParser, []HTTPTransaction) {
+	var parser *recordingParser
+	var reported []HTTPTransaction
+	asm := newStreamAssembler(&streamFactory{
+		parsers: map[string]StreamParserFactory{
+			"http": newHTTPParser,
+			"recording": func(StreamConn, func(MAC, interface{})) StreamParser {
+				p := &recordingParser{data: make(map[MAC]string)}
+				if parser == nil {
+					parser = p
+				}
+				return p
+			},
+		},
+		report: func(mac MAC, result interface{}) {
+			reported = append(reported, result.(HTTPTransaction))
+		},
+	}, ttl)
+	// A capture from long ago, to show that only packet time matters.
+	start := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	for _, s := range segments {
+		asm.Assemble(newTCPPacket(t, s, start))
+	}
+	if close {
+		asm.Close()
+	}
+	if parser == nil {
+		t.Fatal("no stream was created")
+	}
+	return parser, reported
+}
+
+const (
+	clientISN = 1000
+	serverISN = 5000
+)
+
+var (
+	streamRequest  = "GET /a HTTP/1.1\r\nHost: example.com\r\n\r\n"
+	streamResponse = "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nhi"
+)
+
+func TestStreamAssemblerReorders(t *testing.T) {
+	req1, req2 := streamRequest[:10], streamRequest[10:]
+	resp1, resp2 := streamResponse[:20], streamResponse[20:]
+	handshake := []tcpSegment{
+		{seq: clientISN, syn: true},
+		{fromServer: true, seq: serverISN, syn: true, at: time.Millisecond},
+	}
+	inOrder := append(handshake[:2:2],
+		tcpSegment{seq: clientISN + 1, data: req1, at: 2 * time.Millisecond},
+		tcpSegment{seq: clientISN + 1 + 10, data: req2, at: 3 * time.Millisecond},
+		tcpSegment{fromServer: true, seq: serverISN + 1, data: resp1, at: 4 * time.Millisecond},
+		tcpSegment{fromServer: true, seq: serverISN + 1 + 20, data: resp2, at: 5 * time.Millisecond},
+	)
+	outOfOrder := append(handshake[:2:2],
+		tcpSegment{seq: clientISN + 1 + 10, data: req2, at: 2 * time.Millisecond},
+		tcpSegment{fromServer: true, seq: serverISN + 1 + 20, data: resp2, at: 3 * time.Millisecond},
+		tcpSegment{seq: clientISN + 1, data: req1, at: 4 * time.Millisecond},
+		// A retransmission of data already seen.
+		tcpSegment{seq: clientISN + 1, data: req1, at: 5 * time.Millisecond},
+		tcpSegment{fromServer: true, seq: serverISN + 1, data: resp1, at: 6 * time.Millisecond},
+	)
+
+	want := map[MAC]string{
+		MAC(clientMAC.String()): streamRequest,
+		MAC(serverMAC.String()): streamResponse,
+	}
+	wantHTTP := []HTTPTransaction{{
+		Method: "GET",
+		Host:   "example.com",
+		Path:   "/a",
+		Status: 200,
+	}}
+	for name, segments := range map[string][]tcpSegment{
+		"in order":     inOrder,
+		"out of order": outOfOrder,
+	} {
+		p, reported := assembleSegments(t, segments, ttlStream, true)
+		if !reflect.DeepEqual(p.data, want) {
+			t.Errorf("%s: assembled %q, want %q", name, p.data, want)
+		}
+		if p.gaps != 0 {
+			t.Errorf("%s: assembled %d gaps, want none", name, p.gaps)
+		}
+		if !p.closed {
+			t.Errorf("%s: stream wasn't closed", name)
+		}
+		if !reflect.DeepEqual(reported, wantHTTP) {
+			t.Errorf("%s: parsed %+v, want %+v", name, reported, wantHTTP)
+		}
+	}
+}
+
+func TestStreamAssemblerMissingData(t *testing.T) {
+	segments := []tcpSegment{
+		{seq: clientISN, syn: true},
+		{seq: clientISN + 1 + 10, data: streamRequest[10:], at: time.Millisecond},
+	}
+	p, _ := assembleSegments(t, segments, ttlStream, true)
+	if p.gaps != 1 {
+		t.Errorf("assembled %d gaps, want 1", p.gaps)
+	}
+	if got := p.data[MAC(clientMAC.String())]; got != streamRequest[10:] {
+		t.Errorf("assembled %q after the gap, want %q", got, streamRequest[10:])
+	}
+}
+
+func TestStreamAssemblerFlushesByPacketTime(t *testing.T) {
+	ttl := time.Minute
+	segments := []tcpSegment{
+		{seq: clientISN, syn: true},
+		{seq: clientISN + 1, data: streamRequest, at: time.Millisecond},
+	}
+	// Packets of another connection, captured before and long after the
+	// first went idle, only flush it after.
+	other := func(at time.Duration) tcpSegment {
+		return tcpSegment{clientPort: 40001, seq: clientISN, syn: true, at: at}
+	}
+	p, _ := assembleSegments(t, append(segments, other(ttl/2)), ttl, false)
+	if p.closed {
+		t.Errorf("stream was closed before it was idle")
+	}
+	p, _ = assembleSegments(t, append(segments, other(ttl+streamFlushInterval+time.Second)), ttl, false)
+	if !p.closed {
+		t.Errorf("idle stream wasn't closed")
+	}
+}
@@ -2,6 +2,7 @@ package watch
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 )
@@ -16,6 +17,11 @@ type Subscriber func(e Event) error
 type FilteredSubscriber struct {
 	Sub      Subscriber
 	ShouldDo func(e Event) bool
+
+	// spec and limiter are those of a trigger loaded from config, so that
+	// its limiter can be kept when reloading an unchanged trigger.
+	spec    TriggerSpec
+	limiter *triggerLimiter
 }
 
 // NewSubNull does nothing for each event. This is useful for debugging
@@ -28,9 +34,10 @@ func NewSubNull(log *logrus.Logger) Subscriber {
 }
 
 // NewSubLogger returns a new logging Subscriber. For each event, some
-// hopefully useful information is logged.
+// hopefully useful information is logged, along with any enrich data.
 func NewSubLogger(log *logrus.Logger) Subscriber {
 	return func(e Event) error {
+		log := log.WithFields(logrus.Fields(e.Enrich))
 		switch e.Type {
 		case HostTouch:
 			e := e.Body.(EventHostTouch)
@@ -49,19 +56,108 @@ func NewSubLogger(log *logrus.Logger) Subscriber {
 			log.Infof("host started arp scan %s", e.Host)
 		case HostARPScanStop:
 			e := e.Body.(EventHostARPScanStop)
-			log.Infof("host stopped arp scan %s (up %s)", e.Host, e.Up)
+			log.Infof(
+				"host stopped arp scan %s of %d targets %s-%s (took %s)",
+				e.Host,
+				e.Targets,
+				e.Low,
+				e.High,
+				e.Duration,
+			)
+		case HostPortScanStart:
+			e := e.Body.(EventHostPortScanStart)
+			log.Warnf("host started %s %s", e.Scan, e.Host)
+		case HostPortScanStop:
+			e := e.Body.(EventHostPortScanStop)
+			log.Infof("host stopped %s %s (up %s)", e.Scan, e.Host, e.Up)
+		case HostPingSweepStart:
+			e := e.Body.(EventHostPingSweepStart)
+			log.Warnf("host started ping sweep %s (%d targets)", e.Host, e.Targets)
+		case HostPingSweepStop:
+			e := e.Body.(EventHostPingSweepStop)
+			log.Infof("host stopped ping sweep %s (up %s)", e.Host, e.Up)
+		case HostTracerouteStart:
+			e := e.Body.(EventHostTracerouteStart)
+			log.Infof("host started %s %s", e.Route, e.Host)
+		case HostTracerouteStop:
+			e := e.Body.(EventHostTracerouteStop)
+			log.Infof("host stopped %s %s (up %s)", e.Route, e.Host, e.Up)
+		case HostOSChanged:
+			e := e.Body.(EventHostOSChanged)
+			log.Infof(
+				"host os changed %s: %q -> %q (confidence %.2f)",
+				e.Host,
+				e.Prev,
+				e.Host.OS,
+				e.Host.OSConfidence,
+			)
 		case PortTouch:
 			e := e.Body.(EventPortTouch)
-			log.Infof("touch %s on %s", e.Port, e.Host)
+			log.Infof("touch %s", e.Port.At(*e.Host))
 		case PortNew:
 			e := e.Body.(EventPortNew)
-			log.Infof("new %s on %s", e.Port, e.Host)
+			log.Infof("new %s", e.Port.At(*e.Host))
 		case PortLost:
 			e := e.Body.(EventPortLost)
-			log.Infof("drop %s (up %s) on %s", e.Port, e.Up, e.Host)
+			log.Infof("drop %s (up %s)", e.Port.At(*e.Host), e.Up)
 		case PortFound:
 			e := e.Body.(EventPortFound)
-			log.Infof("return %s (down %s) on %s", e.Port, e.Down, e.Host)
+			log.Infof("return %s (down %s)", e.Port.At(*e.Host), e.Down)
+		case RouterNew:
+			e := e.Body.(EventRouterNew)
+			log.Infof(
+				"new router %s advertising %v (lifetime %s)",
+				e.Host,
+				e.Host.RA.Prefixes,
+				e.Host.RA.Lifetime,
+			)
+		case IPv6RogueRA:
+			e := e.Body.(EventIPv6RogueRA)
+			log.Warnf(
+				"rogue router advertisement from %s at %s: %s",
+				e.Host,
+				e.Src,
+				strings.Join(e.Reasons, ", "),
+			)
+		case ARPSpoof:
+			e := e.Body.(EventARPSpoof)
+			log.Warnf(
+				"arp spoof of %s by %s, previously %s (gateway %t)",
+				e.New.IP,
+				e.New.MAC,
+				e.Old.MAC,
+				e.Gateway,
+			)
+		case IPConflict:
+			e := e.Body.(EventIPConflict)
+			log.Warnf("ip conflict on %s between %s and %s", e.IP, e.Host, hostList(e.Others))
+		case DHCPServerNew:
+			e := e.Body.(EventDHCPServerNew)
+			log.Infof("new dhcp server %s on %s", e.Server.ID, e.Host)
+		case DHCPServerUnauthorized:
+			e := e.Body.(EventDHCPServerUnauthorized)
+			log.Warnf(
+				"unauthorized dhcp %s from %s on %s: %s",
+				e.Reply.Type,
+				e.Server.ID,
+				e.Host,
+				strings.Join(e.Reasons, ", "),
+			)
+		case FlowBeacon:
+			e := e.Body.(EventFlowBeacon)
+			log.Infof("%s from %s", e.Beacon, e.Host)
+		case Digest:
+			e := e.Body.(EventDigest)
+			log.Infof("digest %s: %s", e.Trigger, e.Description)
+		case HTTPRequest:
+			e := e.Body.(EventHTTPRequest)
+			log.Infof(
+				"http %s %s (%d) from %s",
+				e.Request.Method,
+				e.Request.Resource(),
+				e.Request.Status,
+				e.Host,
+			)
 		default:
 			panic(fmt.Sprintf("unhandled event type: %#v", e))
 		}
@@ -0,0 +1,98 @@
+package watch
+
+import (
+	"net"
+	"strings"
+	"time"
+)
+
+// TimingConfig holds how long hosts and ports may go unseen before they are
+// considered lost. Overrides apply to particular hosts, e.g. sleepy IoT
+// devices that only wake up every so often, or always-on servers whose
+// absence should be noticed quickly. Zero values use defaults.
+type TimingConfig struct {
+	Host Duration
+	Port Duration
+	// Stream is how long a TCP stream may be idle before it is flushed.
+	// It applies to all hosts.
+	Stream    Duration
+	Overrides []TimingOverride
+}
+
+// TimingOverride overrides the TimingConfig for hosts with the given MAC
+// address, with a MAC address assigned to the given vendor, or with an IPv4
+// address within the given network. Only one of these should be set.
+//
+// An override for a MAC address takes precedence over one for a vendor, which
+// takes precedence over one for a network.
+type TimingOverride struct {
+	MAC    MAC
+	Vendor string
+	CIDR   CIDR
+	Host   Duration
+	Port   Duration
+}
+
+// Timing holds the resolved timing for one host.
+type Timing struct {
+	Host time.Duration
+	Port time.Duration
+}
+
+// forHost returns the timing for a host with the given MAC address, vendor
+// and IPv4 address.
+func (c TimingConfig) forHost(mac MAC, vendor string, ip net.IP) Timing {
+	t := Timing{Host: ttlHost, Port: ttlPort}
+	apply(&t, c.Host, c.Port)
+	var byMAC, byVendor, byCIDR *TimingOverride
+	for i := range c.Overrides {
+		o := &c.Overrides[i]
+		switch {
+		case o.MAC != "":
+			if o.MAC == mac && byMAC == nil {
+				byMAC = o
+			}
+		case o.Vendor != "":
+			if strings.EqualFold(o.Vendor, vendor) && byVendor == nil {
+				byVendor = o
+			}
+		case o.CIDR.IP != nil:
+			if ip != nil && o.CIDR.Contains(ip) && byCIDR == nil {
+				byCIDR = o
+			}
+		}
+	}
+	for _, o := range []*TimingOverride{byCIDR, byVendor, byMAC} {
+		if o != nil {
+			apply(&t, o.Host, o.Port)
+		}
+	}
+	return t
+}
+
+func apply(t *Timing, host, port Duration) {
+	if host.Duration > 0 {
+		t.Host = host.Duration
+	}
+	if port.Duration > 0 {
+		t.Port = port.Duration
+	}
+}
+
+// streamTTL returns how long a TCP stream may be idle before it is flushed.
+func (c TimingConfig) streamTTL() time.Duration {
+	if c.Stream.Duration > 0 {
+		return c.Stream.Duration
+	}
+	return ttlStream
+}
+
+// timingFor returns the timing for the host with the given MAC address, as
+// shown by the given view, or the host previously seen with it if any.
+func (w *Watcher) timingFor(mac MAC, v View, prev *Host) Timing {
+	ip := v.IPv4
+	if (ip == nil || ip.Equal(net.IPv4zero)) && prev != nil {
+		ip = prev.IPv4
+	}
+	return w.conf.Timing.forHost(mac, lookupVendor(mac), ip)
+}
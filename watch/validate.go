@@ -0,0 +1,612 @@
+package watch
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// ConfigError is a problem with a config file, at the given line of the given
+// key when known.
+type ConfigError struct {
+	Path string
+	Line int
+	// Key is the dotted path of the offending key, e.g.
+	// triggers.log.onEvents[1].
+	Key string
+	Msg string
+}
+
+func (e ConfigError) Error() string {
+	var parts []string
+	if e.Path != "" {
+		parts = append(parts, e.Path)
+	}
+	if e.Line > 0 {
+		parts = append(parts, strconv.Itoa(e.Line))
+	}
+	if e.Key != "" {
+		parts = append(parts, " "+e.Key)
+	}
+	if len(parts) == 0 {
+		return e.Msg
+	}
+	return strings.Join(parts, ":") + ": " + e.Msg
+}
+
+// ConfigErrors are all of the problems found with a config file.
+type ConfigErrors []ConfigError
+
+func (errs ConfigErrors) Error() string {
+	var lines []string
+	for _, e := range errs {
+		lines = append(lines, e.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// reParseError matches the errors returned by the toml parser.
+var reParseError = regexp.MustCompile(`^Near line (\d+) \(last key parsed '([^']*)'\): (.*)$`)
+
+// newParseError converts an error from the toml parser to a ConfigError.
+func newParseError(path string, err error) ConfigError {
+	m := reParseError.FindStringSubmatch(err.Error())
+	if m == nil {
+		return ConfigError{Path: path, Msg: err.Error()}
+	}
+	line, _ := strconv.Atoi(m[1])
+	return ConfigError{Path: path, Line: line, Key: m[2], Msg: m[3]}
+}
+
+// configPos is the file and line that a config key was set at.
+type configPos struct {
+	path string
+	line int
+}
+
+// filePos returns the position of each key of the file at the given path, from
+// their lines.
+func filePos(path string, lines map[string]int) map[string]configPos {
+	pos := make(map[string]configPos, len(lines))
+	for key, line := range lines {
+		pos[key] = configPos{path: path, line: line}
+	}
+	return pos
+}
+
+// lookupPos returns the position of the given key, or of its closest parent
+// that has one, or else just the given path.
+func lookupPos(pos map[string]configPos, key string, path string) configPos {
+	for key != "" {
+		if p, ok := pos[key]; ok {
+			return p
+		}
+		i := strings.LastIndexAny(key, ".[")
+		if i < 0 {
+			break
+		}
+		key = key[:i]
+	}
+	return configPos{path: path}
+}
+
+// configValidator collects the problems found with a config, locating them by
+// key. Keys may have been set in different files, when the config includes
+// others, where the given path is the config's own.
+type configValidator struct {
+	path string
+	pos  map[string]configPos
+	errs ConfigErrors
+}
+
+func newConfigValidator(path string, pos map[string]configPos) *configValidator {
+	return &configValidator{path: path, pos: pos}
+}
+
+// err returns the problems found so far ordered by file and line, or nil if
+// there are none.
+func (v *configValidator) err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	sortConfigErrors(v.errs)
+	return v.errs
+}
+
+func sortConfigErrors(errs ConfigErrors) {
+	sort.SliceStable(errs, func(i, j int) bool {
+		if errs[i].Path != errs[j].Path {
+			return errs[i].Path < errs[j].Path
+		}
+		return errs[i].Line < errs[j].Line
+	})
+}
+
+// errorf records a problem with the given key.
+func (v *configValidator) errorf(key []string, format string, args ...interface{}) {
+	k := joinKey(key)
+	p := lookupPos(v.pos, k, v.path)
+	v.errs = append(v.errs, ConfigError{
+		Path: p.path,
+		Line: p.line,
+		Key:  k,
+		Msg:  fmt.Sprintf(format, args...),
+	})
+}
+
+func joinKey(key []string) string {
+	var b strings.Builder
+	for i, k := range key {
+		if strings.HasPrefix(k, "[") {
+			b.WriteString(k)
+			continue
+		}
+		if i > 0 {
+			b.WriteString(".")
+		}
+		b.WriteString(k)
+	}
+	return b.String()
+}
+
+func index(i int) string {
+	return "[" + strconv.Itoa(i) + "]"
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// checkValue checks that the given decoded toml data can be decoded into a
+// value of the given type, reporting unknown keys and malformed values.
+func (v *configValidator) checkValue(key []string, data interface{}, t reflect.Type) {
+	if reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		var s string
+		switch d := data.(type) {
+		case string:
+			s = d
+		case int64, float64, bool:
+			s = fmt.Sprint(d)
+		default:
+			v.errorf(key, "expected a string but found %s", tomlType(data))
+			return
+		}
+		u := reflect.New(t).Interface().(encoding.TextUnmarshaler)
+		if err := u.UnmarshalText([]byte(s)); err != nil {
+			v.errorf(key, "%v", err)
+		}
+		return
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := data.(map[string]interface{})
+		if !ok {
+			v.errorf(key, "expected a table but found %s", tomlType(data))
+			return
+		}
+		for _, k := range sortedKeys(m) {
+			f, ok := structField(t, k)
+			if !ok {
+				v.errorf(append(key, k), "unknown key%s", suggest(k, structKeys(t)))
+				continue
+			}
+			v.checkValue(append(key, k), m[k], f.Type)
+		}
+	case reflect.Map:
+		m, ok := data.(map[string]interface{})
+		if !ok {
+			v.errorf(key, "expected a table but found %s", tomlType(data))
+			return
+		}
+		for _, k := range sortedKeys(m) {
+			v.checkValue(append(key, k), m[k], t.Elem())
+		}
+	case reflect.Slice:
+		switch d := data.(type) {
+		case []interface{}:
+			for i, e := range d {
+				v.checkValue(append(key, index(i)), e, t.Elem())
+			}
+		case []map[string]interface{}:
+			for i, e := range d {
+				v.checkValue(append(key, index(i)), e, t.Elem())
+			}
+		default:
+			v.errorf(key, "expected an array but found %s", tomlType(data))
+		}
+	case reflect.String:
+		if _, ok := data.(string); !ok {
+			v.errorf(key, "expected a string but found %s", tomlType(data))
+		}
+	case reflect.Bool:
+		if _, ok := data.(bool); !ok {
+			v.errorf(key, "expected a boolean but found %s", tomlType(data))
+		}
+	case reflect.Int:
+		if _, ok := data.(int64); !ok {
+			v.errorf(key, "expected an integer but found %s", tomlType(data))
+		}
+	case reflect.Float64:
+		switch data.(type) {
+		case int64, float64:
+		default:
+			v.errorf(key, "expected a number but found %s", tomlType(data))
+		}
+	}
+}
+
+// structField returns the field of the given struct type that the given key
+// decodes into, matching its toml tag or name like the toml decoder does.
+func structField(t reflect.Type, key string) (reflect.StructField, bool) {
+	var fold *reflect.StructField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := fieldKey(f)
+		if name == key {
+			return f, true
+		}
+		if fold == nil && strings.EqualFold(name, key) {
+			fold = &f
+		}
+	}
+	if fold != nil {
+		return *fold, true
+	}
+	return reflect.StructField{}, false
+}
+
+// fieldKey returns the key of the given field, as written in config files.
+func fieldKey(f reflect.StructField) string {
+	if tag := f.Tag.Get("toml"); tag != "" {
+		return strings.Split(tag, ",")[0]
+	}
+	// Lower a leading initialism as a whole, e.g. TTL as ttl, and CIDRs as
+	// cidrs, but keep the start of the next word, e.g. RDNSServers as
+	// rdnsServers.
+	name := f.Name
+	n := 0
+	for n < len(name) && name[n] >= 'A' && name[n] <= 'Z' {
+		n++
+	}
+	if n > 1 && n < len(name) && name[n] >= 'a' && name[n] <= 'z' && name[n] != 's' {
+		n--
+	}
+	if n == 0 {
+		n = 1
+	}
+	return strings.ToLower(name[:n]) + name[n:]
+}
+
+func structKeys(t reflect.Type) []string {
+	var keys []string
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i); f.PkgPath == "" {
+			keys = append(keys, fieldKey(f))
+		}
+	}
+	return keys
+}
+
+func tomlType(data interface{}) string {
+	switch data.(type) {
+	case string:
+		return "a string"
+	case int64:
+		return "an integer"
+	case float64:
+		return "a float"
+	case bool:
+		return "a boolean"
+	case map[string]interface{}:
+		return "a table"
+	case []interface{}, []map[string]interface{}:
+		return "an array"
+	case nil:
+		return "null"
+	}
+	return fmt.Sprintf("%T", data)
+}
+
+// suggest returns a hint of the closest of the given keys to the given unknown
+// key, if any are close.
+func suggest(key string, keys []string) string {
+	best, bestDist := "", len(key)/2+1
+	for _, k := range keys {
+		if d := editDistance(strings.ToLower(key), strings.ToLower(k)); d < bestDist {
+			best, bestDist = k, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(", did you mean %q?", best)
+}
+
+// editDistance returns the Levenshtein distance between the given strings.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(a int, rest ...int) int {
+	for _, b := range rest {
+		if b < a {
+			a = b
+		}
+	}
+	return a
+}
+
+// validate checks this Config for conflicting or missing options, that can't
+// be found from its types alone.
+func (conf *Config) validate(v *configValidator) {
+	for _, name := range sortedTriggerNames(conf.Triggers) {
+		conf.Triggers[name].validate(v, []string{"triggers", name})
+	}
+	for i, o := range conf.Timing.Overrides {
+		key := []string{"timing", "overrides", index(i)}
+		var n int
+		for _, set := range []bool{o.MAC != "", o.Vendor != "", o.CIDR.IP != nil} {
+			if set {
+				n++
+			}
+		}
+		if n != 1 {
+			v.errorf(key, "exactly one of mac, vendor or cidr must be set")
+		}
+	}
+	if c := conf.Beacon.MinConfidence; c < 0 || c > 1 {
+		v.errorf([]string{"beacon", "minConfidence"}, "must be between 0 and 1")
+	}
+}
+
+// validate checks this TriggerSpec, under the given key, for conflicting or
+// missing options.
+func (spec TriggerSpec) validate(v *configValidator, key []string) {
+	on := 0
+	for _, set := range []bool{
+		spec.OnAny,
+		len(spec.OnEvents) > 0,
+		len(spec.OnEventsExcept) > 0,
+		spec.OnShell != "",
+		spec.OnExpr != "",
+	} {
+		if set {
+			on++
+		}
+	}
+	switch {
+	case on == 0:
+		v.errorf(key, "one of onAny, onEvents, onEventsExcept, onShell or onExpr must be set")
+	case on > 1:
+		v.errorf(key, "only one of onAny, onEvents, onEventsExcept, onShell or onExpr may be set")
+	}
+	switch {
+	case spec.DoBuiltin == "" && spec.DoShell == "":
+		v.errorf(key, "one of doBuiltin or doShell must be set")
+	case spec.DoBuiltin != "" && spec.DoShell != "":
+		v.errorf(key, "only one of doBuiltin or doShell may be set")
+	case spec.DoBuiltin != "":
+		if !isBuiltin(spec.DoBuiltin) {
+			v.errorf(append(key, "doBuiltin"), "unknown builtin %q, expected one of %s",
+				spec.DoBuiltin, strings.Join(builtins, ", "))
+		}
+	}
+	if spec.DedupWindow.Duration != 0 && spec.DedupKey == "" {
+		v.errorf(append(key, "dedupWindow"), "dedupKey must also be set")
+	}
+	if spec.DigestTemplate != "" && spec.Digest.Duration == 0 {
+		v.errorf(append(key, "digestTemplate"), "digest must also be set")
+	}
+	for _, d := range []struct {
+		name string
+		dur  Duration
+	}{
+		{"dedupWindow", spec.DedupWindow},
+		{"cooldown", spec.Cooldown},
+		{"digest", spec.Digest},
+		{"timeout", spec.Timeout},
+	} {
+		if d.dur.Duration < 0 {
+			v.errorf(append(key, d.name), "must not be negative")
+		}
+	}
+	if spec.OnExpr != "" {
+		if _, err := CompileExpr(spec.OnExpr); err != nil {
+			v.errorf(append(key, "onExpr"), "invalid expression: %v", err)
+		}
+	}
+	if spec.Workers < 0 {
+		v.errorf(append(key, "workers"), "must not be negative")
+	}
+	for i, step := range spec.Enrich {
+		key := append(append([]string(nil), key...), "enrich", index(i))
+		switch {
+		case step.Builtin == "" && step.Shell == "":
+			v.errorf(key, "one of builtin or shell must be set")
+		case step.Builtin != "" && step.Shell != "":
+			v.errorf(key, "only one of builtin or shell may be set")
+		case step.Builtin != "":
+			if !isEnrichBuiltin(step.Builtin) {
+				v.errorf(append(key, "builtin"), "unknown builtin %q, expected one of %s",
+					step.Builtin, strings.Join(enrichBuiltins, ", "))
+			}
+		case step.Shell != "":
+			if _, err := newShellTemplate(step.Shell); err != nil {
+				v.errorf(append(key, "shell"), "invalid template: %v", err)
+			}
+		}
+	}
+	for _, f := range []struct {
+		name  string
+		text  string
+		shell bool
+	}{
+		{"onShell", spec.OnShell, true},
+		{"doShell", spec.DoShell, true},
+		{"dedupKey", spec.DedupKey, false},
+		{"digestTemplate", spec.DigestTemplate, false},
+	} {
+		if f.text == "" {
+			continue
+		}
+		var err error
+		if f.shell {
+			_, err = newShellTemplate(f.text)
+		} else {
+			_, err = template.New("").Parse(f.text)
+		}
+		if err != nil {
+			v.errorf(append(key, f.name), "invalid template: %v", err)
+		}
+	}
+}
+
+// keyLines returns the line of each key and table in the given toml text,
+// keyed by their dotted path, where arrays of tables are indexed, e.g.
+// timing.overrides[0].host.
+func keyLines(text string) map[string]int {
+	lines := make(map[string]int)
+	arrays := make(map[string]int)
+	var prefix string
+	var multiline string
+	for i, line := range strings.Split(text, "\n") {
+		n := i + 1
+		line = strings.TrimSpace(line)
+		if multiline != "" {
+			if strings.Contains(line, multiline) {
+				multiline = ""
+			}
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[[") {
+			end := strings.Index(line, "]]")
+			if end < 0 {
+				continue
+			}
+			table := joinDotted(line[2:end])
+			arrays[table]++
+			prefix = table + index(arrays[table]-1)
+			lines[prefix] = n
+			setParentLines(lines, prefix, n)
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			end := strings.Index(line, "]")
+			if end < 0 {
+				continue
+			}
+			prefix = indexArrays(joinDotted(line[1:end]), arrays)
+			lines[prefix] = n
+			setParentLines(lines, prefix, n)
+			continue
+		}
+		eq := strings.Index(line, "=")
+		if eq < 0 {
+			continue
+		}
+		key := joinDotted(line[:eq])
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		if _, ok := lines[key]; !ok {
+			lines[key] = n
+		}
+		value := strings.TrimSpace(line[eq+1:])
+		for _, q := range []string{`"""`, `'''`} {
+			if strings.HasPrefix(value, q) && !strings.Contains(value[3:], q) {
+				multiline = q
+			}
+		}
+	}
+	return lines
+}
+
+// setParentLines locates the implicitly defined parent tables of the given
+// table, including an array of tables itself, at the given line of their first
+// child.
+func setParentLines(lines map[string]int, table string, n int) {
+	for i := len(table); i > 0; {
+		if strings.HasSuffix(table[:i], "]") {
+			i = strings.LastIndex(table[:i], "[")
+		} else {
+			i = strings.LastIndex(table[:i], ".")
+		}
+		if i <= 0 {
+			return
+		}
+		if _, ok := lines[table[:i]]; !ok {
+			lines[table[:i]] = n
+		}
+	}
+}
+
+// joinDotted normalizes a possibly quoted and dotted toml key.
+func joinDotted(s string) string {
+	var parts []string
+	for _, p := range strings.Split(s, ".") {
+		p = strings.TrimSpace(p)
+		p = strings.Trim(p, `"'`)
+		parts = append(parts, p)
+	}
+	return strings.Join(parts, ".")
+}
+
+// indexArrays indexes each prefix of the given table that is an array of
+// tables, to its latest element, e.g. a sub-table of timing.overrides.
+func indexArrays(table string, arrays map[string]int) string {
+	parts := strings.Split(table, ".")
+	var out string
+	for i, p := range parts {
+		if i > 0 {
+			out += "."
+		}
+		out += p
+		if n, ok := arrays[strings.Join(parts[:i+1], ".")]; ok {
+			out += index(n - 1)
+		}
+	}
+	return out
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedTriggerNames(triggers map[string]TriggerSpec) []string {
+	var names []string
+	for name := range triggers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,178 @@
+package watch
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestKeyLines(t *testing.T) {
+	text := `# A comment = 1.
+beacon.minSamples = 4
+
+[triggers."log"]
+  onEvents = ["host.new"]
+  doShell = """
+    notify = "not a key"
+  """
+  'doBuiltin' = "log"
+
+[[timing.overrides]]
+  vendor = "Espressif"
+[[timing.overrides]]
+  mac = "00:00:5e:00:53:01"
+  [timing.overrides.extra]
+    host = "1h"
+`
+	want := map[string]int{
+		"beacon.minSamples":              2,
+		"triggers":                       4,
+		"triggers.log":                   4,
+		"triggers.log.onEvents":          5,
+		"triggers.log.doShell":           6,
+		"triggers.log.doBuiltin":         9,
+		"timing":                         11,
+		"timing.overrides":               11,
+		"timing.overrides[0]":            11,
+		"timing.overrides[0].vendor":     12,
+		"timing.overrides[1]":            13,
+		"timing.overrides[1].mac":        14,
+		"timing.overrides[1].extra":      15,
+		"timing.overrides[1].extra.host": 16,
+	}
+	got := keyLines(text)
+	for key, line := range want {
+		if got[key] != line {
+			t.Errorf("%s is on line %d, want %d", key, got[key], line)
+		}
+	}
+	for key := range got {
+		if _, ok := want[key]; !ok {
+			t.Errorf("found unexpected key %s on line %d", key, got[key])
+		}
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	keys := []string{"onAny", "onEvents", "onEventsExcept", "doBuiltin", "doShell"}
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"onEvent", `, did you mean "onEvents"?`},
+		{"OnEventsExept", `, did you mean "onEventsExcept"?`},
+		{"doshel", `, did you mean "doShell"?`},
+		{"rateLimit", ""},
+		{"x", ""},
+	}
+	for _, tt := range tests {
+		if got := suggest(tt.key, keys); got != tt.want {
+			t.Errorf("suggested %q for %s, want %q", got, tt.key, tt.want)
+		}
+	}
+}
+
+func TestFieldKey(t *testing.T) {
+	type fields struct {
+		OnEvents    int
+		TTL         int
+		CIDRs       int
+		RDNSServers int
+		Tagged      int `toml:"other,omitempty"`
+	}
+	want := []string{"onEvents", "ttl", "cidrs", "rdnsServers", "other"}
+	if got := structKeys(reflect.TypeOf(fields{})); !reflect.DeepEqual(got, want) {
+		t.Errorf("keyed fields as %q, want %q", got, want)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netwatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.toml")
+
+	tests := []struct {
+		name string
+		text string
+		// want are the errors expected, after the path of the config.
+		want []string
+	}{
+		{
+			name: "valid",
+			text: "[triggers.log]\n  onAny = true\n  doBuiltin = \"log\"\n",
+		},
+		{
+			name: "unknown keys",
+			text: "[trigger.log]\n  onAny = true\n\n[triggers.log]\n" +
+				"  onAny = true\n  doBuiltin = \"log\"\n  frobnicate = 1\n",
+			want: []string{
+				`:1: trigger: unknown key, did you mean "triggers"?`,
+				`:7: triggers.log.frobnicate: unknown key`,
+			},
+		},
+		{
+			name: "onAny and onEvents",
+			text: "[triggers.log]\n  onAny = true\n  onEvents = [\"host.new\"]\n  doBuiltin = \"log\"\n",
+			want: []string{
+				":1: triggers.log: only one of onAny, onEvents, onEventsExcept, onShell or onExpr may be set",
+			},
+		},
+		{
+			name: "missing filter and action",
+			text: "[triggers.a]\n  rateLimit = \"5/m\"\n[triggers.b]\n  onAny = true\n",
+			want: []string{
+				":1: triggers.a: one of onAny, onEvents, onEventsExcept, onShell or onExpr must be set",
+				":1: triggers.a: one of doBuiltin or doShell must be set",
+				":3: triggers.b: one of doBuiltin or doShell must be set",
+			},
+		},
+		{
+			name: "malformed values",
+			text: "[triggers.log]\n  onAny = \"yes\"\n  onEvents = [\"host.nu\"]\n" +
+				"  doBuiltin = \"log\"\n  cooldown = \"soon\"\n",
+			want: []string{
+				":2: triggers.log.onAny: expected a boolean but found a string",
+				`:3: triggers.log.onEvents[0]: unknown event type "host.nu"`,
+				`:5: triggers.log.cooldown: time: invalid duration "soon"`,
+			},
+		},
+		{
+			name: "timing overrides",
+			text: "[[timing.overrides]]\n  vendor = \"Espressif\"\n" +
+				"[[timing.overrides]]\n  vendor = \"Espressif\"\n  mac = \"00:00:5e:00:53:01\"\n",
+			want: []string{
+				":3: timing.overrides[1]: exactly one of mac, vendor or cidr must be set",
+			},
+		},
+	}
+	for _, tt := range tests {
+		if err := ioutil.WriteFile(path, []byte(tt.text), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := LoadConfig(path)
+		if len(tt.want) == 0 {
+			if err != nil {
+				t.Errorf("%s: failed to load: %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: loaded, want errors %q", tt.name, tt.want)
+			continue
+		}
+		got := strings.Split(err.Error(), "\n")
+		for i := range got {
+			got[i] = strings.TrimPrefix(got[i], path)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: failed with\n%s\nwant\n%s", tt.name,
+				strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+		}
+	}
+}
@@ -1,16 +1,14 @@
 package watch
 
 import (
-	"bytes"
 	"context"
 	"fmt"
-	"html/template"
-	"os"
-	"os/exec"
+	"reflect"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/BurntSushi/toml"
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/pcap"
 	"github.com/pkg/errors"
@@ -23,6 +21,21 @@ type Watcher struct {
 	log    *logrus.Logger
 	events chan Event
 	subs   []Subscriber
+
+	streamParsers map[string]StreamParserFactory
+	conns         *connTable
+	conf          *Config
+	arp           map[string]*ARPBinding
+	arpClaims     map[string]*ARPBinding
+	ips           *ipClaims
+	dhcpServers   map[string]*DHCPServer
+	beacons       *beaconFlows
+	onLink        *onLinkNets
+	clock         clock
+
+	// mu guards the state of hosts that expiry callbacks change, since
+	// they run outside of the goroutine scanning packets.
+	mu sync.Mutex
 }
 
 // NewWatcher creates a new watcher initialized with the given subscribers.
@@ -34,9 +47,27 @@ func NewWatcher(log *logrus.Logger, subs ...Subscriber) *Watcher {
 		log:    log,
 		events: make(chan Event, 32),
 		subs:   subs,
+
+		streamParsers: defaultStreamParsers(),
+		conns:         newConnTable(),
+		conf:          new(Config),
+		arp:           make(map[string]*ARPBinding),
+		arpClaims:     make(map[string]*ARPBinding),
+		ips:           newIPClaims(),
+		dhcpServers:   make(map[string]*DHCPServer),
+		beacons:       newBeaconFlows(),
+		onLink:        new(onLinkNets),
+		clock:         wallClock{},
 	}
 }
 
+// UseConfig sets the policies that events are checked against. This must be
+// called before watching.
+func (w *Watcher) UseConfig(conf *Config) {
+	w.conf = conf
+	w.conns.ttl = conf.Timing.streamTTL()
+}
+
 // Watch scans the given src for packets, and publish resultant Events to all
 // of it's registered Subscribers.
 func (w *Watcher) Watch(ctx context.Context, src *gopacket.PacketSource) error {
@@ -51,6 +82,9 @@ func (w *Watcher) WatchLive(ctx context.Context, iface string) error {
 	if err != nil {
 		return err
 	}
+	if err := w.onLink.addInterface(iface); err != nil {
+		w.log.WithError(err).Warnf("only learning on-link addresses from traffic")
+	}
 	src := gopacket.NewPacketSource(h, h.LinkType())
 	return w.Watch(ctx, src)
 
@@ -62,10 +96,22 @@ func (w *Watcher) WatchPCAP(ctx context.Context, pcapPath string) error {
 	if err != nil {
 		return err
 	}
+	// Hosts, ports and scans expire by the time of the packets being
+	// replayed, rather than how quickly they're read.
+	w.clock = newReplayClock()
 	src := gopacket.NewPacketSource(h, h.LinkType())
 	return w.Watch(ctx, src)
 }
 
+// emit sends the given event to be published, stamped with the time of the
+// latest packet if it has no time of its own.
+func (w *Watcher) emit(e Event) {
+	if e.Timestamp.IsZero() {
+		e.Timestamp = w.clock.Now()
+	}
+	w.events <- e
+}
+
 // Publish endlessly reads incomming events, and sends a shallow copy of that
 // event to each of this Watcher's Subscribers.
 func (w *Watcher) Publish() error {
@@ -79,36 +125,54 @@ func (w *Watcher) Publish() error {
 	return nil
 }
 
-// NewSubConfig returns a new Subscriber
+// NewSubConfig returns a new Subscriber, which runs the triggers of the given
+// config, as returned by LoadConfig. The config is reloaded whenever it or any
+// file it includes changes, or the process receives SIGHUP, until the given
+// context is done. An invalid new config is rejected, and the previous
+// triggers keep running. Only triggers are reloaded, and changes to the
+// policies of the config are warned about, since they need a restart.
 func NewSubConfig(
+	ctx context.Context,
 	log *logrus.Logger,
-	path string,
+	conf *Config,
 	only []string,
 ) (Subscriber, error) {
-	var conf Config
-	if _, err := toml.DecodeFile(path, &conf); err != nil {
+	path := conf.path
+	// Each set of triggers has its own context, which is done once it has
+	// been replaced, to stop any of its digests.
+	setCtx, cancel := context.WithCancel(ctx)
+	triggers, err := newTriggers(setCtx, log, conf, only, nil)
+	if err != nil {
+		cancel()
 		return nil, err
 	}
-	// TODO: validate config, e.g. not on event and on events, etc.
+	var curr atomic.Value
+	curr.Store(triggers)
 
-	triggers := make(map[string]FilteredSubscriber)
-	onlySet := stringSet(only)
-	for name, spec := range conf.Triggers {
-		if len(onlySet) > 0 && !onlySet[name] {
-			continue
+	go watchConfig(ctx, log, path, conf.sources, func() []string {
+		nextCtx, nextCancel := context.WithCancel(ctx)
+		prev := curr.Load().(map[string]FilteredSubscriber)
+		next, triggers, err := loadTriggers(nextCtx, log, path, conf.profiles, only, prev)
+		if err != nil {
+			nextCancel()
+			log.WithError(err).Errorf(
+				"rejected config %s, keeping previous triggers",
+				path,
+			)
+			return nil
 		}
-		log.Debugf("loading subscriber %s", name)
-		trig := newTriggerFromConfig(log, name, spec)
-		if spec.Disabled && !onlySet[name] {
-			continue
+		curr.Store(triggers)
+		cancel()
+		cancel = nextCancel
+		log.Infof("reloaded %d triggers from %s", len(triggers), path)
+		if !conf.samePolicies(next) {
+			log.Warnf("only triggers were reloaded from %s, other changes need a restart", path)
 		}
-		triggers[name] = trig
-	}
-	if len(triggers) == 0 {
-		log.Fatal("no subscribers loaded")
-	}
+		return next.sources
+	})
 
 	return func(e Event) error {
+		triggers := curr.Load().(map[string]FilteredSubscriber)
 		for name, trig := range triggers {
 			if !trig.ShouldDo(e) {
 				continue
@@ -121,57 +185,203 @@ func NewSubConfig(
 	}, nil
 }
 
+// loadTriggers loads the config at the given path, and its enabled triggers,
+// or only those named if any are given. Unchanged triggers keep their limits
+// from the given previous triggers.
+func loadTriggers(
+	ctx context.Context,
+	log *logrus.Logger,
+	path string,
+	profiles []string,
+	only []string,
+	prev map[string]FilteredSubscriber,
+) (*Config, map[string]FilteredSubscriber, error) {
+	conf, err := LoadConfig(path, profiles...)
+	if err != nil {
+		return nil, nil, err
+	}
+	triggers, err := newTriggers(ctx, log, conf, only, prev)
+	if err != nil {
+		return nil, nil, err
+	}
+	return conf, triggers, nil
+}
+
+// newTriggers returns the enabled triggers of the given config, or only those
+// named if any are given. Triggers whose spec is unchanged from one of the
+// given previous triggers keep its limiter, so that reloading doesn't reset
+// their rate limits, dedup keys and cooldowns.
+func newTriggers(
+	ctx context.Context,
+	log *logrus.Logger,
+	conf *Config,
+	only []string,
+	prev map[string]FilteredSubscriber,
+) (map[string]FilteredSubscriber, error) {
+	triggers := make(map[string]FilteredSubscriber)
+	onlySet := stringSet(only)
+	for name, spec := range conf.Triggers {
+		if len(onlySet) > 0 && !onlySet[name] {
+			continue
+		}
+		if spec.Disabled && !onlySet[name] {
+			continue
+		}
+		log.Debugf("loading subscriber %s", name)
+		var limiter *triggerLimiter
+		if p, ok := prev[name]; ok && reflect.DeepEqual(p.spec, spec) {
+			limiter = p.limiter
+		}
+		trig, err := newTriggerFromConfig(ctx, log, name, spec, limiter)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to load trigger %s", name)
+		}
+		triggers[name] = trig
+	}
+	if len(triggers) == 0 {
+		return nil, errors.Errorf("no subscribers loaded from %s", conf.path)
+	}
+	return triggers, nil
+}
+
+// newTriggerFromConfig returns the trigger of the given spec, limited by the
+// given limiter if any. Its digests and shell commands are run until the given
+// context is done.
 func newTriggerFromConfig(
+	ctx context.Context,
 	log *logrus.Logger,
 	name string,
 	spec TriggerSpec,
-) FilteredSubscriber {
+	limiter *triggerLimiter,
+) (FilteredSubscriber, error) {
 	var sub Subscriber
 	if spec.DoBuiltin != "" {
-		sub = newSubFromBuiltin(log, spec.DoBuiltin)
+		var err error
+		sub, err = newSubFromBuiltin(log, spec.DoBuiltin)
+		if err != nil {
+			return FilteredSubscriber{}, err
+		}
+		en, err := newEnricher(log, name, spec)
+		if err != nil {
+			return FilteredSubscriber{}, err
+		}
+		if en != nil {
+			// Enrich steps may be slow, so they're run by workers,
+			// as doShell commands are, rather than blocking other
+			// Subscribers.
+			do := sub
+			sub = newWorkerPool(ctx, spec.Workers, func(e Event) {
+				if err := do(en.Enrich(e)); err != nil {
+					log.WithError(err).Errorf("failed to run builtin of trigger %s", name)
+				}
+			})
+		}
 	}
 	if spec.DoShell != "" {
-		sub = newSubFromShell(context.TODO(), log, spec.DoShell)
+		var err error
+		sub, err = newSubFromShell(ctx, log, name, spec)
+		if err != nil {
+			return FilteredSubscriber{}, err
+		}
 	}
 	if sub == nil {
-		log.Fatalf(
-			"failed to construct a trigger, "+
-				"did you fill out doBuiltin or doShell?: %#v",
-			spec,
+		return FilteredSubscriber{}, errors.New(
+			"failed to construct a trigger, " +
+				"did you fill out doBuiltin or doShell?",
 		)
 	}
+	shouldDo, err := newShouldDo(log, name, spec)
+	if err != nil {
+		return FilteredSubscriber{}, err
+	}
+	if limiter == nil {
+		limiter, err = newTriggerLimiter(log, name, spec)
+		if err != nil {
+			return FilteredSubscriber{}, err
+		}
+	}
+	trig := FilteredSubscriber{
+		Sub:      sub,
+		ShouldDo: shouldDo,
+		spec:     spec,
+		limiter:  limiter,
+	}
+	if limiter != nil {
+		matches := trig.ShouldDo
+		trig.ShouldDo = func(e Event) bool {
+			return matches(e) && limiter.Allow(e, eventTime(e))
+		}
+	}
+	if spec.Digest.Duration > 0 {
+		d, err := newDigester(log, name, spec, sub)
+		if err != nil {
+			return FilteredSubscriber{}, err
+		}
+		go d.run(ctx)
+		trig.Sub = d.Add
+	}
+	return trig, nil
+}
+
+// newShouldDo returns whether events match the given trigger, ignoring its
+// rate limits.
+func newShouldDo(
+	log *logrus.Logger,
+	name string,
+	spec TriggerSpec,
+) (func(e Event) bool, error) {
 	var shouldDo func(e Event) bool
 	if spec.OnShell != "" {
-		shouldDo = newShouldDoFromShell(context.TODO(), log, spec.OnShell)
+		var err error
+		shouldDo, err = newShouldDoFromShell(log, name, spec)
+		if err != nil {
+			return nil, err
+		}
 	}
-	return FilteredSubscriber{
-		Sub: sub,
-		ShouldDo: func(e Event) bool {
-			if spec.OnAny {
-				return true
-			}
-			if shouldDo != nil {
-				return shouldDo(e)
-			}
-			if len(spec.OnEventsExcept) > 0 {
-				for _, ty := range spec.OnEventsExcept {
-					if ty == e.Type {
-						return false
-					}
-				}
-				return true
-			}
-			for _, ty := range spec.OnEvents {
+	if spec.OnExpr != "" {
+		x, err := CompileExpr(spec.OnExpr)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid onExpr")
+		}
+		shouldDo = x.Match
+	}
+	return func(e Event) bool {
+		if spec.OnAny {
+			return true
+		}
+		if shouldDo != nil {
+			return shouldDo(e)
+		}
+		if len(spec.OnEventsExcept) > 0 {
+			for _, ty := range spec.OnEventsExcept {
 				if ty == e.Type {
-					return true
+					return false
 				}
 			}
-			return false
-		},
+			return true
+		}
+		for _, ty := range spec.OnEvents {
+			if ty == e.Type {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// builtins are the names of the Subscribers that can be used for doBuiltin.
+var builtins = []string{"null", "log"}
+
+func isBuiltin(builtin string) bool {
+	for _, b := range builtins {
+		if strings.EqualFold(b, builtin) {
+			return true
+		}
 	}
+	return false
 }
 
-func newSubFromBuiltin(log *logrus.Logger, builtin string) Subscriber {
+func newSubFromBuiltin(log *logrus.Logger, builtin string) (Subscriber, error) {
 	var sub Subscriber
 	switch strings.ToLower(builtin) {
 	case "null":
@@ -179,83 +389,29 @@ func newSubFromBuiltin(log *logrus.Logger, builtin string) Subscriber {
 	case "log":
 		sub = NewSubLogger(log)
 	default:
-		panic(fmt.Sprintf("unsupported sub name: '%s'", builtin))
-	}
-	return sub
-}
-
-func newSubFromShell(
-	ctx context.Context,
-	log *logrus.Logger,
-	shell string,
-) Subscriber {
-	return func(e Event) error {
-		shell = os.ExpandEnv(shell)
-		tmpl, err := template.New("").Parse(shell)
-		if err != nil {
-			return err
-		}
-		info := newEventInfo(e)
-		var buf bytes.Buffer
-		err = tmpl.Execute(&buf, info)
-		if err != nil {
-			return err
-		}
-		cmd := exec.CommandContext(ctx, "/bin/sh", "-c", buf.String())
-		b, err := cmd.CombinedOutput()
-		if err != nil {
-			return errors.Wrapf(err, "failed to run command: %v", string(b))
-		}
-		out := strings.TrimSpace(string(b))
-		fmt.Println(out)
-		return nil
-	}
-}
-
-func newShouldDoFromShell(
-	ctx context.Context,
-	log *logrus.Logger,
-	shell string,
-) func(e Event) bool {
-	shell = os.ExpandEnv(shell)
-	tmpl, err := template.New("").Parse(shell)
-	if err != nil {
-		log.WithError(err).Fatalf("failed to template parse shell: %s", shell)
-	}
-	return func(e Event) bool {
-		info := newEventInfo(e)
-		var buf bytes.Buffer
-		err = tmpl.Execute(&buf, info)
-		if err != nil {
-			log.WithError(err).Fatalf("failed to execute template")
-			return false
-		}
-		cmd := exec.CommandContext(ctx, "/bin/sh", "-c", buf.String())
-		b, err := cmd.CombinedOutput()
-		if err != nil {
-			// The point of this shell command is to return a
-			// non-zero exit code when an event should be skipped.
-			// However, we also log so as to not preclude
-			// debugging.
-			log.Debugf("failed to run output: %v: %s", err, string(b))
-			return false
-		}
-		return true
+		return nil, errors.Errorf("unsupported sub name: '%s'", builtin)
 	}
+	return sub, nil
 }
 
 type printableEvent struct {
 	Description string
+	Severity    string
 	Host        Host
 	Port        Port
 	PortString  string
+	HTTP        HTTPTransaction
 	Up          time.Duration
 	Down        time.Duration
 	Age         time.Duration
+	Digest      EventDigest
+	Enrich      map[string]interface{}
 }
 
 func newEventInfo(e Event) printableEvent {
 	var pe printableEvent
+	pe.Severity = e.Type.Severity().String()
+	pe.Enrich = e.Enrich
 	switch e.Type {
 	case HostTouch:
 		e := e.Body.(EventHostTouch)
@@ -264,7 +420,7 @@ func newEventInfo(e Event) printableEvent {
 			"touched host %s at %s (up %s) (age %s)",
 			e.Host.MAC,
 			e.Host.IPv4,
-			time.Since(e.Host.Activity.FirstSeenEpisode),
+			e.Host.Activity.Up(),
 			e.Host.Activity.Age(),
 		)
 	case HostNew:
@@ -309,8 +465,74 @@ func newEventInfo(e Event) printableEvent {
 		e := e.Body.(EventHostARPScanStop)
 		pe.Host = *e.Host
 		pe.Description = fmt.Sprintf(
-			"%s stopped arp scan",
+			"%s stopped arp scan of %d targets %s-%s (took %s)",
+			e.Host,
+			e.Targets,
+			e.Low,
+			e.High,
+			e.Duration,
+		)
+	case HostPortScanStart:
+		e := e.Body.(EventHostPortScanStart)
+		pe.Host = *e.Host
+		pe.Description = fmt.Sprintf(
+			"%s started %s",
+			e.Host,
+			e.Scan,
+		)
+	case HostPortScanStop:
+		e := e.Body.(EventHostPortScanStop)
+		pe.Host = *e.Host
+		pe.Description = fmt.Sprintf(
+			"%s stopped %s (up %s)",
+			e.Host,
+			e.Scan,
+			e.Up,
+		)
+	case HostPingSweepStart:
+		e := e.Body.(EventHostPingSweepStart)
+		pe.Host = *e.Host
+		pe.Description = fmt.Sprintf(
+			"%s started ping sweep of %d targets",
+			e.Host,
+			e.Targets,
+		)
+	case HostPingSweepStop:
+		e := e.Body.(EventHostPingSweepStop)
+		pe.Host = *e.Host
+		pe.Description = fmt.Sprintf(
+			"%s stopped ping sweep (up %s)",
+			e.Host,
+			e.Up,
+		)
+	case HostTracerouteStart:
+		e := e.Body.(EventHostTracerouteStart)
+		pe.Host = *e.Host
+		pe.Description = fmt.Sprintf(
+			"%s started %s",
+			e.Host,
+			e.Route,
+		)
+	case HostTracerouteStop:
+		e := e.Body.(EventHostTracerouteStop)
+		pe.Host = *e.Host
+		pe.Description = fmt.Sprintf(
+			"%s stopped %s via %s (up %s)",
 			e.Host,
+			e.Route,
+			e.Route.Hops,
+			e.Up,
+		)
+	case HostOSChanged:
+		e := e.Body.(EventHostOSChanged)
+		pe.Host = *e.Host
+		pe.Description = fmt.Sprintf(
+			"host %s at %s os changed %q -> %q (confidence %.2f)",
+			e.Host.MAC,
+			e.Host.IPv4,
+			e.Prev,
+			e.Host.OS,
+			e.Host.OSConfidence,
 		)
 	case PortTouch:
 		e := e.Body.(EventPortTouch)
@@ -318,9 +540,8 @@ func newEventInfo(e Event) printableEvent {
 		pe.Host = *e.Host
 		pe.PortString = e.Port.String()
 		pe.Description = fmt.Sprintf(
-			"touched port %s at %s (up %s)",
-			pe.Port,
-			pe.Host.IPv4,
+			"touched port %s (up %s)",
+			pe.Port.At(pe.Host),
 			pe.Host.Activity.Age(),
 		)
 	case PortNew:
@@ -328,10 +549,19 @@ func newEventInfo(e Event) printableEvent {
 		pe.Port = *e.Port
 		pe.Host = *e.Host
 		pe.PortString = e.Port.String()
+		if e.Port.Role == PortClient {
+			pe.Description = fmt.Sprintf(
+				"%s connected to %s on %s (age %s)",
+				e.Host.IPv4,
+				e.Port,
+				e.Port.Remote,
+				e.Port.Activity.Age(),
+			)
+			break
+		}
 		pe.Description = fmt.Sprintf(
-			"new port %s at %s (age %s)",
-			e.Port,
-			e.Host.IPv4,
+			"new port %s (age %s)",
+			e.Port.At(*e.Host),
 			e.Port.Activity.Age(),
 		)
 	case PortLost:
@@ -341,9 +571,8 @@ func newEventInfo(e Event) printableEvent {
 		pe.Host = *e.Host
 		pe.PortString = e.Port.String()
 		pe.Description = fmt.Sprintf(
-			"new port %s at %s (up %s) (age %s)",
-			e.Port,
-			e.Host.IPv4,
+			"new port %s (up %s) (age %s)",
+			e.Port.At(*e.Host),
 			e.Up,
 			e.Port.Activity.Age(),
 		)
@@ -354,12 +583,82 @@ func newEventInfo(e Event) printableEvent {
 		pe.Host = *e.Host
 		pe.PortString = e.Port.String()
 		pe.Description = fmt.Sprintf(
-			"found port %s at %s (down %s) (age %s)",
-			e.Port,
-			e.Host.IPv4,
+			"found port %s (down %s) (age %s)",
+			e.Port.At(*e.Host),
 			e.Down,
 			e.Port.Activity.Age(),
 		)
+	case RouterNew:
+		e := e.Body.(EventRouterNew)
+		pe.Host = *e.Host
+		pe.Description = fmt.Sprintf(
+			"new router %s at %s advertising %v",
+			e.Host.MAC,
+			e.Host.IPv6,
+			e.Host.RA.Prefixes,
+		)
+	case IPv6RogueRA:
+		e := e.Body.(EventIPv6RogueRA)
+		pe.Host = *e.Host
+		pe.Description = fmt.Sprintf(
+			"rogue router advertisement from %s at %s: %s",
+			e.Host.MAC,
+			e.Src,
+			strings.Join(e.Reasons, ", "),
+		)
+	case ARPSpoof:
+		e := e.Body.(EventARPSpoof)
+		pe.Host = *e.Host
+		pe.Description = fmt.Sprintf(
+			"arp spoof of %s by %s, previously %s (last seen %s ago)",
+			e.New.IP,
+			e.New.MAC,
+			e.Old.MAC,
+			e.New.LastSeen.Sub(e.Old.LastSeen),
+		)
+	case IPConflict:
+		e := e.Body.(EventIPConflict)
+		pe.Host = *e.Host
+		pe.Description = fmt.Sprintf(
+			"ip conflict on %s between %s and %s",
+			e.IP,
+			e.Host,
+			hostList(e.Others),
+		)
+	case DHCPServerNew:
+		e := e.Body.(EventDHCPServerNew)
+		pe.Host = *e.Host
+		pe.Description = fmt.Sprintf("new dhcp server %s on %s", e.Server.ID, e.Host)
+	case DHCPServerUnauthorized:
+		e := e.Body.(EventDHCPServerUnauthorized)
+		pe.Host = *e.Host
+		pe.Description = fmt.Sprintf(
+			"unauthorized dhcp %s from %s on %s: %s",
+			e.Reply.Type,
+			e.Server.ID,
+			e.Host,
+			strings.Join(e.Reasons, ", "),
+		)
+	case FlowBeacon:
+		e := e.Body.(EventFlowBeacon)
+		pe.Host = *e.Host
+		pe.Description = fmt.Sprintf("%s from %s", e.Beacon, e.Host)
+	case Digest:
+		e := e.Body.(EventDigest)
+		pe.Digest = e
+		pe.Description = e.Description
+	case HTTPRequest:
+		e := e.Body.(EventHTTPRequest)
+		pe.Host = *e.Host
+		pe.HTTP = e.Request
+		pe.Description = fmt.Sprintf(
+			"http %s %s (%d) from %s at %s",
+			e.Request.Method,
+			e.Request.Resource(),
+			e.Request.Status,
+			e.Host.MAC,
+			e.Host.IPv4,
+		)
 	default:
 		panic(fmt.Sprintf("unhandled event type: %#v", e))
 	}